@@ -3,83 +3,263 @@ package nsxbot
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
-	"reflect"
 	"runtime"
 	"slices"
-	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nsxdevx/nsxbot/driver"
 	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/metrics"
 	"github.com/nsxdevx/nsxbot/nlog"
+	"github.com/nsxdevx/nsxbot/storage"
+	"github.com/tidwall/gjson"
 )
 
 type HandlerEnd[T any] struct {
+	route    string
 	fillers  FilterChain[T]
 	handlers HandlersChain[T]
+	pool     *Pool
+	priority int
+	block    bool
+	reg      *Registration
 }
 
 type EventHandler[T any] struct {
 	selfIds []int64
 	Composer[T]
+	mu          sync.RWMutex
 	handlerEnds []HandlerEnd[T]
+	unhandled   *HandlerEnd[T]
+	pool        *Pool
+	orderKey    func(event event.Event) string
+	orderer     orderer
+	engine      *Engine
 	log         *slog.Logger
 }
 
+// SetPool runs the handlers in pool, handlers added by Composer.WithPool use their own pool.
+// Without pool every handler runs in a new goroutine.
+func (h *EventHandler[T]) SetPool(pool *Pool) {
+	h.pool = pool
+}
+
+// Unhandled sets the handler of the events no other handler matched, it runs after all other handlers.
+func (h *EventHandler[T]) Unhandled(handler HandlerFunc[T]) *Registration {
+	reg := &Registration{}
+	unhandled := &HandlerEnd[T]{
+		route:    h.routeOf(handler),
+		handlers: slices.Concat(h.handlers, HandlersChain[T]{handler}),
+		pool:     h.Composer.pool,
+		reg:      reg,
+	}
+	reg.remove = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.unhandled == unhandled {
+			h.unhandled = nil
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unhandled = unhandled
+	return reg
+}
+
+func (h *EventHandler[T]) snapshot() ([]HandlerEnd[T], *HandlerEnd[T]) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.handlerEnds, h.unhandled
+}
+
 func (h *EventHandler[T]) selfs() ([]int64, bool) {
 	return h.selfIds, len(h.selfIds) != 0
 }
 
 func (h *EventHandler[T]) infos() []string {
 	var infos []string
-	for _, handlerEnd := range h.handlerEnds {
-		var info string
-		info += handlerEnd.fillers.debug()
-		handler := runtime.FuncForPC(reflect.ValueOf(handlerEnd.handlers[len(handlerEnd.handlers)-1]).Pointer()).Name()
-		handler = strings.TrimPrefix(handler, "main.main.")
-		info += handler
-		infos = append(infos, info)
+	handlerEnds, unhandled := h.snapshot()
+	for _, handlerEnd := range handlerEnds {
+		infos = append(infos, handlerEnd.fillers.debug()+handlerEnd.route)
+	}
+	if unhandled != nil {
+		infos = append(infos, "unhandled->"+unhandled.route)
 	}
 	return infos
 }
 
-func (h *EventHandler[T]) consume(ctx context.Context, emitter driver.Emitter, event event.Event) error {
+func (h *EventHandler[T]) consume(ctx context.Context, emitter driver.Emitter, event event.Event, ticket *ticket) error {
 	var msg T
 	if err := json.Unmarshal(event.RawData, &msg); err != nil {
 		return err
 	}
-	h.log.Debug("Consumed", "types", event.Types, "time", event.Time, "selfId", event.SelfId)
-	for _, handlerEnd := range h.handlerEnds {
-		go func() {
+	log := eventLogger(h.log, event)
+	log.Debug("Consumed")
+	ctxLog := eventLogger(nlog.Logger(), event)
+	handlerEnds, unhandled := h.snapshot()
+	launch := func(handlerEnd HandlerEnd[T], result *propagation, release func()) {
+		pool := handlerEnd.pool
+		if pool == nil {
+			pool = h.pool
+		}
+		run := func() {
+			if handlerEnd.reg.Paused() || handlerEnd.reg.Removed() {
+				return
+			}
 			for _, filter := range handlerEnd.fillers {
 				if !filter(msg) {
+					filterRejections.Inc(handlerEnd.route)
 					return
 				}
 			}
-			h.log.Debug("Handled", "types", event.Types, "time", event.Time, "selfId", event.SelfId, "filter", handlerEnd.fillers.debug())
-			nsxctx := NewContext(ctx, emitter, event.Time, event.SelfId, msg, event.Replyer)
+			log.Debug("Handled", "filter", handlerEnd.fillers.debug(), "route", handlerEnd.route)
+			handlerCtx, cancel := driver.WithSendPriority(ctx, driver.PriorityReply), context.CancelFunc(func() {})
+			if h.engine.handlerTimeout > 0 {
+				handlerCtx, cancel = context.WithTimeout(handlerCtx, h.engine.handlerTimeout)
+			}
+			nsxctx := NewContext(handlerCtx, emitter, event.SelfId, event.Time, msg, event.Replyer)
+			nsxctx.Log = ctxLog.With("route", handlerEnd.route)
 			nsxctx.handlers = handlerEnd.handlers
+			nsxctx.storage = h.engine.storage
+			nsxctx.engine = h.engine
+			nsxctx.route = handlerEnd.route
+			nsxctx.detach = h.engine.detach
+			nsxctx.registration = handlerEnd.reg
+			nsxctx.cancel = cancel
+			result.matched.Store(true)
+			start := time.Now()
 			nsxctx.Next()
-		}()
+			handlerDuration.Since(start, handlerEnd.route)
+			if errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+				handlerTimeouts.Inc(handlerEnd.route)
+				nsxctx.Log.Warn("Handler timeout", "timeout", h.engine.handlerTimeout)
+			}
+			nsxctx.handleErrors()
+			if nsxctx.cancel != nil {
+				nsxctx.cancel()
+			}
+			if handlerEnd.block || nsxctx.blocked {
+				result.blocked.Store(true)
+			}
+		}
+		if pool == nil {
+			go func() {
+				defer release()
+				run()
+			}()
+			return
+		}
+		pool.submit(event.Types, run, release)
+	}
+	if ticket == nil && unhandled == nil && !prioritized(handlerEnds) {
+		for _, handlerEnd := range handlerEnds {
+			h.engine.inflight.Add(1)
+			launch(handlerEnd, &propagation{}, h.engine.inflight.Done)
+		}
+		return nil
 	}
+	h.engine.inflight.Add(1)
+	go func() {
+		defer h.engine.inflight.Done()
+		defer ticket.release()
+		if ticket != nil {
+			<-ticket.wait
+		}
+		// run the handlers level by level, a level waits for the previous one to know whether it blocked the event
+		var result propagation
+		for level := range levels(handlerEnds) {
+			var handlers sync.WaitGroup
+			for _, handlerEnd := range level {
+				handlers.Add(1)
+				launch(handlerEnd, &result, handlers.Done)
+			}
+			handlers.Wait()
+			if result.blocked.Load() {
+				log.Debug("Blocked", "priority", level[0].priority)
+				break
+			}
+		}
+		if unhandled != nil && !result.matched.Load() {
+			done := make(chan struct{})
+			launch(*unhandled, &result, func() { close(done) })
+			<-done
+		}
+	}()
 	return nil
 }
 
+// propagation collects the results of the handlers of an event.
+type propagation struct {
+	matched atomic.Bool
+	blocked atomic.Bool
+}
+
+// prioritized reports whether the handlers need to run level by level.
+func prioritized[T any](handlerEnds []HandlerEnd[T]) bool {
+	for _, handlerEnd := range handlerEnds {
+		if handlerEnd.block || handlerEnd.priority != handlerEnds[0].priority {
+			return true
+		}
+	}
+	return false
+}
+
+// levels yields the handlers with the same priority, from the smallest priority.
+func levels[T any](handlerEnds []HandlerEnd[T]) iter.Seq[[]HandlerEnd[T]] {
+	return func(yield func([]HandlerEnd[T]) bool) {
+		ends := handlerEnds
+		for len(ends) != 0 {
+			n := 1
+			for n < len(ends) && ends[n].priority == ends[0].priority {
+				n++
+			}
+			if !yield(ends[:n]) {
+				return
+			}
+			ends = ends[n:]
+		}
+	}
+}
+
+// eventLogger attaches the trace id, self id, event type and the chat ids found in the event to log.
+func eventLogger(log *slog.Logger, event event.Event) *slog.Logger {
+	args := []any{"trace", event.TraceId, "selfId", event.SelfId}
+	if len(event.Types) != 0 {
+		args = append(args, "type", event.Types[len(event.Types)-1])
+	}
+	for _, field := range []string{"group_id", "user_id", "message_id"} {
+		if value := gjson.GetBytes(event.RawData, field); value.Exists() {
+			args = append(args, field, value.Int())
+		}
+	}
+	return log.With(args...)
+}
+
 type consumer interface {
 	selfs() ([]int64, bool)
 	infos() []string
-	consume(ctx context.Context, emitter driver.Emitter, event event.Event) error
+	reserve(event event.Event) *ticket
+	consume(ctx context.Context, emitter driver.Emitter, event event.Event, ticket *ticket) error
 }
 
 func SubEvent[T any](engine *Engine, eventype string, selfIds ...int64) *EventHandler[T] {
 	handler := &EventHandler[T]{
-		log: engine.log,
+		engine: engine,
+		log:    engine.log,
 	}
 	// root a pointer to the beginning of the middleware chain
 	handler.root = handler
 	handler.Use(Recovery[T]())
 
+	engine.consumerMu.Lock()
+	defer engine.consumerMu.Unlock()
 	engine.consumers[eventype] = handler
 	return handler
 }
@@ -101,29 +281,55 @@ type Engine struct {
 	emitterMux  driver.EmitterMux
 	taskLen     int
 	consumerNum int
+	overflow    OverflowPolicy
+	dropTypes   []string
+	consumerMu  sync.RWMutex
 	consumers   map[string]consumer
-	log         *slog.Logger
+	storage     storage.Storage
+	jobmu       sync.Mutex
+	jobs        []*Job
+	jobctx      context.Context
+	jobRunCtx   context.Context
+	metricsAddr string
+	inflight    sync.WaitGroup
+	// guards stopping, see Engine.track
+	inflightMu      sync.Mutex
+	stopping        bool
+	shutdownTimeout time.Duration
+	handlerTimeout  time.Duration
+	errorHandlers   []ErrorHandler
+	panicReporters  []PanicReporter
+	panicLimit      int
+	panicWindow     time.Duration
+	panics          panicCounter
+	shutdownHooks   []func(ctx context.Context)
+	interceptors    []driver.Interceptor
+	log             *slog.Logger
 }
 
 func Default(driver driver.Driver) *Engine {
 	return &Engine{
-		listener:    driver,
-		emitterMux:  driver,
-		taskLen:     10,
-		consumerNum: runtime.NumCPU(),
-		consumers:   make(map[string]consumer),
-		log:         nlog.Logger(),
+		listener:        driver,
+		emitterMux:      driver,
+		taskLen:         10,
+		consumerNum:     runtime.NumCPU(),
+		consumers:       make(map[string]consumer),
+		storage:         storage.NewMemory(),
+		shutdownTimeout: 10 * time.Second,
+		log:             nlog.Component("engine"),
 	}
 }
 
 func New(listener driver.Listener, emitterMux driver.EmitterMux) *Engine {
 	return &Engine{
-		listener:    listener,
-		emitterMux:  emitterMux,
-		taskLen:     10,
-		consumerNum: runtime.NumCPU(),
-		consumers:   make(map[string]consumer),
-		log:         nlog.Logger(),
+		listener:        listener,
+		emitterMux:      emitterMux,
+		taskLen:         10,
+		consumerNum:     runtime.NumCPU(),
+		consumers:       make(map[string]consumer),
+		storage:         storage.NewMemory(),
+		shutdownTimeout: 10 * time.Second,
+		log:             nlog.Component("engine"),
 	}
 }
 
@@ -131,12 +337,34 @@ func (e *Engine) SetTaskLen(taskLen int) {
 	e.taskLen = taskLen
 }
 
+// SetThrottle sends the messages of every bot through a send queue limiting how fast it sends, see driver.NewThrottledMux.
+// Handlers send replies before the messages of jobs.
+func (e *Engine) SetThrottle(opts ...driver.ThrottleOption) {
+	e.emitterMux = driver.NewThrottledMux(e.emitterMux, opts...)
+}
+
 func (e *Engine) SetConsumerNum(consumerNum int) {
 	e.consumerNum = consumerNum
 }
 
+// SetStorage sets the key-value storage used by Context.Bucket, default is an in-memory storage.
+// nil sets a new in-memory storage.
+func (e *Engine) SetStorage(s storage.Storage) {
+	if s == nil {
+		s = storage.NewMemory()
+	}
+	e.storage = s
+}
+
+// Storage returns the key-value storage of the engine.
+func (e *Engine) Storage() storage.Storage {
+	return e.storage
+}
+
 func (e *Engine) debug() {
-	e.log.Info("Engine", "taskLen", e.taskLen, "consumerGoruntineNum", e.consumerNum)
+	e.log.Info("Engine", "taskLen", e.taskLen, "consumerGoruntineNum", e.consumerNum, "overflow", e.overflow)
+	e.consumerMu.RLock()
+	defer e.consumerMu.RUnlock()
 	e.log.Info("Consumers", "num", len(e.consumers))
 	for t, consumer := range e.consumers {
 		for _, info := range consumer.infos() {
@@ -150,45 +378,114 @@ func (e *Engine) debug() {
 			e.log.Info("Consumer", "chain", chain)
 		}
 	}
+	e.jobmu.Lock()
+	defer e.jobmu.Unlock()
+	for _, job := range e.jobs {
+		e.log.Info("Job", "name", job.name, "selfIds", job.selfIds, "location", job.location)
+	}
 }
 
-func (e *Engine) consumerStart(ctx context.Context, task <-chan event.Event) {
+// consumerStart moves the events from the listener into the task queue until stop is closed.
+func (e *Engine) consumerStart(ctx context.Context, stop <-chan struct{}, task <-chan event.Event, queue *Pool) {
 	for {
 		select {
-		case <-ctx.Done():
+		case <-stop:
 			return
 		case event := <-task:
-			e.log.Debug("Received", "types", event.Types, "time", event.Time, "selfId", event.SelfId)
-			for _, Type := range event.Types {
-				if consumer, ok := e.consumers[Type]; ok {
-					if selfIds, ok := consumer.selfs(); ok && !slices.Contains(selfIds, event.SelfId) {
-						continue
-					}
-					emitter, err := e.emitterMux.GetEmitter(event.SelfId)
-					if err != nil {
-						e.log.Error("GetEmitter error", "error", err)
-						continue
-					}
-					if err := consumer.consume(context.Background(), emitter, event); err != nil {
-						e.log.Error("Consume error", "error", err)
-						continue
-					}
+			reserved := e.reserve(event)
+			dispatched := false
+			queue.submit(event.Types, func() {
+				dispatched = true
+				e.dispatch(ctx, event, reserved)
+			}, func() {
+				// the event was dropped by the task queue
+				if !dispatched {
+					reserved.release()
 				}
+			})
+		}
+	}
+}
+
+func (e *Engine) consumer(Type string) (consumer, bool) {
+	e.consumerMu.RLock()
+	defer e.consumerMu.RUnlock()
+	consumer, ok := e.consumers[Type]
+	return consumer, ok
+}
+
+func (e *Engine) dispatch(ctx context.Context, event event.Event, reserved tickets) {
+	e.log.Debug("Received", "trace", event.TraceId, "types", event.Types, "time", event.Time, "selfId", event.SelfId)
+	if len(event.Types) != 0 {
+		eventsReceived.Inc(event.Types[len(event.Types)-1], strconv.FormatInt(event.SelfId, 10))
+	}
+	for _, Type := range event.Types {
+		if consumer, ok := e.consumer(Type); ok {
+			ticket := reserved[Type]
+			if selfIds, ok := consumer.selfs(); ok && !slices.Contains(selfIds, event.SelfId) {
+				ticket.release()
+				continue
+			}
+			emitter, err := e.emitter(event.SelfId)
+			if err != nil {
+				ticket.release()
+				e.log.Error("GetEmitter error", "error", err)
+				continue
+			}
+			traced := nlog.WithTraceId(ctx, event.TraceId)
+			// quick replies go through the interceptors like the messages of emitter
+			consumed := event
+			if intercepted, ok := emitter.(*driver.Intercepted); ok && event.Replyer != nil {
+				consumed.Replyer = intercepted.Replyer(traced, event.Replyer, event.RawData)
+			}
+			if err := consumer.consume(traced, emitter, consumed, ticket); err != nil {
+				ticket.release()
+				e.log.Error("Consume error", "error", err)
+				continue
 			}
 		}
 	}
 }
 
-func (e *Engine) Run(ctx context.Context) {
+// Run starts the engine and blocks until ctx is done or the listener fails,
+// then it drains accepted events, waits for running handlers and closes the drivers.
+func (e *Engine) Run(ctx context.Context) error {
 	e.debug()
-	task := make(chan event.Event, e.taskLen)
-	for range e.consumerNum {
-		go e.consumerStart(ctx, task)
-	}
-	if nlog.Leveler == slog.LevelDebug {
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	// handlers outlive ctx until the shutdown timeout
+	handlerCtx, cancelHandlers := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelHandlers(nil)
+
+	task := make(chan event.Event)
+	queue := NewPool("engine", PoolConfig{
+		Workers:   e.consumerNum,
+		QueueSize: e.taskLen,
+		Overflow:  e.overflow,
+		DropTypes: e.dropTypes,
+	})
+	metrics.Default.GaugeFunc("nsxbot_task_queue_length", "Events waiting in the engine task queue.", func() float64 {
+		return float64(queue.Len())
+	})
+	e.serveMetrics(runCtx)
+	stop := make(chan struct{})
+	accepted := make(chan struct{})
+	go func() {
+		defer close(accepted)
+		e.consumerStart(handlerCtx, stop, task, queue)
+	}()
+	e.startJobs(runCtx, handlerCtx)
+	if nlog.Level() == slog.LevelDebug {
 		e.log.Warn("Run in debug mode, please set env NSX_MODE=release or nlog.SetLevel(slog.LevelInfo) to disable debug mode.")
 	}
-	if err := e.listener.Listen(ctx, task); err != nil {
-		panic(err)
+	err := e.listener.Listen(runCtx, task)
+	if err != nil {
+		e.log.Error("Listener error", "err", err)
 	}
+	cancelRun()
+	close(stop)
+	<-accepted
+	// drain the events already accepted before stopping
+	queue.close()
+	return errors.Join(err, e.shutdown(cancelHandlers))
 }
@@ -1,8 +1,10 @@
 package nsxbot
 
 import (
+	"cmp"
 	"reflect"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/nsxdevx/nsxbot/filter"
@@ -13,11 +15,15 @@ type FilterChain[T any] []filter.Filter[T]
 func (f FilterChain[T]) debug() string {
 	var info string
 	for _, filter := range f {
-		info += strings.TrimPrefix(runtime.FuncForPC(reflect.ValueOf(filter).Pointer()).Name()+"->", "main.main.")
+		info += funcName(filter) + "->"
 	}
 	return info
 }
 
+func funcName(fn any) string {
+	return strings.TrimPrefix(runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name(), "main.main.")
+}
+
 type HandlersChain[T any] []HandlerFunc[T]
 
 type HandlerFunc[T any] func(ctx *Context[T])
@@ -25,6 +31,10 @@ type HandlerFunc[T any] func(ctx *Context[T])
 type Composer[T any] struct {
 	handlers HandlersChain[T]
 	filters  FilterChain[T]
+	pool     *Pool
+	priority int
+	block    bool
+	route    string
 	root     *EventHandler[T]
 }
 
@@ -38,22 +48,103 @@ func (c *Composer[T]) Filit(fillers ...filter.Filter[T]) {
 	c.filters = append(c.filters, fillers...)
 }
 
-// Compose creates a new Composer with the given filters.
-func (c *Composer[T]) Compose(fillers ...filter.Filter[T]) *Composer[T] {
+// clone copies the Composer, appending to the copy never changes the handlers of c.
+func (c *Composer[T]) clone() *Composer[T] {
 	return &Composer[T]{
-		handlers: c.handlers,
+		handlers: slices.Clip(c.handlers),
+		filters:  slices.Clip(c.filters),
+		pool:     c.pool,
+		priority: c.priority,
+		block:    c.block,
+		route:    c.route,
 		root:     c.root,
-		filters:  c.combineFilters(fillers),
 	}
 }
 
-// Handle adds a handler to the Composer.
-func (c *Composer[T]) Handle(handler HandlerFunc[T], filters ...filter.Filter[T]) {
+// Compose creates a new Composer with the given filters.
+func (c *Composer[T]) Compose(fillers ...filter.Filter[T]) *Composer[T] {
+	composer := c.clone()
+	composer.filters = c.combineFilters(fillers)
+	return composer
+}
+
+// WithPool creates a new Composer whose handlers run in pool, a pool can be shared by several Composers.
+func (c *Composer[T]) WithPool(pool *Pool) *Composer[T] {
+	composer := c.clone()
+	composer.pool = pool
+	return composer
+}
+
+// Priority creates a new Composer whose handlers run with priority, default is 0.
+// Handlers with a smaller priority run first, handlers with the same priority run concurrently.
+func (c *Composer[T]) Priority(priority int) *Composer[T] {
+	composer := c.clone()
+	composer.priority = priority
+	return composer
+}
+
+// Block creates a new Composer whose handlers stop the event from reaching handlers with a larger priority
+// when their filters match, see Context.Block to decide it in the handler.
+func (c *Composer[T]) Block() *Composer[T] {
+	composer := c.clone()
+	composer.block = true
+	return composer
+}
+
+// Route creates a new Composer whose handlers are named route in logs, metrics and panic reports,
+// such as a plugin name. Default is the function name of the handler, which closures and
+// NewConversation handlers share.
+func (c *Composer[T]) Route(route string) *Composer[T] {
+	composer := c.clone()
+	composer.route = route
+	return composer
+}
+
+func (c *Composer[T]) routeOf(handler any) string {
+	if len(c.route) != 0 {
+		return c.route
+	}
+	return funcName(handler)
+}
+
+// Handle adds a handler to the Composer, it is safe to call while the engine is running.
+func (c *Composer[T]) Handle(handler HandlerFunc[T], filters ...filter.Filter[T]) *Registration {
+	return c.handle(c.routeOf(handler), handler, filters)
+}
+
+// HandleE adds a handler returning an error to the Composer, see Engine.OnError.
+func (c *Composer[T]) HandleE(handler ErrHandlerFunc[T], filters ...filter.Filter[T]) *Registration {
+	return c.handle(c.routeOf(handler), WithError(handler), filters)
+}
+
+func (c *Composer[T]) handle(route string, handler HandlerFunc[T], filters FilterChain[T]) *Registration {
+	root := c.root
+	reg := &Registration{}
 	handlerEnd := HandlerEnd[T]{
+		route:    route,
 		fillers:  c.combineFilters(filters),
-		handlers: append(c.handlers, handler),
+		handlers: slices.Concat(c.handlers, HandlersChain[T]{handler}),
+		pool:     c.pool,
+		priority: c.priority,
+		block:    c.block,
+		reg:      reg,
+	}
+	reg.remove = func() {
+		root.mu.Lock()
+		defer root.mu.Unlock()
+		root.handlerEnds = slices.DeleteFunc(slices.Clone(root.handlerEnds), func(end HandlerEnd[T]) bool {
+			return end.reg == reg
+		})
 	}
-	c.root.handlerEnds = append(c.root.handlerEnds, handlerEnd)
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	// keep handlerEnds sorted by priority, in registration order for the same priority
+	i, _ := slices.BinarySearchFunc(root.handlerEnds, c.priority+1, func(end HandlerEnd[T], priority int) int {
+		return cmp.Compare(end.priority, priority)
+	})
+	// consume iterates a snapshot of handlerEnds after releasing the lock, never modify the slice in place
+	root.handlerEnds = slices.Insert(slices.Clip(root.handlerEnds), i, handlerEnd)
+	return reg
 }
 
 func (c *Composer[T]) combineFilters(filters FilterChain[T]) FilterChain[T] {
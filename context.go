@@ -8,9 +8,10 @@ import (
 	"github.com/nsxdevx/nsxbot/driver"
 	"github.com/nsxdevx/nsxbot/event"
 	"github.com/nsxdevx/nsxbot/nlog"
+	"github.com/nsxdevx/nsxbot/storage"
 )
 
-const abortIndex int8 = math.MaxInt8 >> 1
+const abortIndex = math.MaxInt >> 1
 
 type Context[T any] struct {
 	context.Context
@@ -22,8 +23,19 @@ type Context[T any] struct {
 	Msg    T
 	Log    *slog.Logger
 
-	index    int8
-	handlers HandlersChain[T]
+	index        int
+	handlers     HandlersChain[T]
+	keys         map[string]any
+	route        string
+	storage      storage.Storage
+	engine       *Engine
+	blocked      bool
+	errors       []error
+	registration *Registration
+	// cancels the handler context, taken by a detached handler which outlives the chain
+	cancel context.CancelFunc
+	// runs handlers waiting for later events outside of the pool, see Engine.detach
+	detach func(fn func())
 }
 
 func NewContext[T any](ctx context.Context, emitter driver.Emitter, selfId int64, time int64, data T, Replyer event.Replyer) Context[T] {
@@ -41,7 +53,7 @@ func NewContext[T any](ctx context.Context, emitter driver.Emitter, selfId int64
 
 func (c *Context[T]) Next() {
 	c.index++
-	for c.index < int8(len(c.handlers)) {
+	for c.index < len(c.handlers) {
 		if c.handlers[c.index] != nil {
 			c.handlers[c.index](c)
 		}
@@ -52,3 +64,67 @@ func (c *Context[T]) Next() {
 func (c *Context[T]) Abort() {
 	c.index = abortIndex
 }
+
+// Set stores value for the handlers after this one, such as parsed arguments or a loaded profile.
+func (c *Context[T]) Set(key string, value any) {
+	if c.keys == nil {
+		c.keys = make(map[string]any)
+	}
+	c.keys[key] = value
+}
+
+// Get returns the value stored by Set.
+func (c *Context[T]) Get(key string) (any, bool) {
+	value, ok := c.keys[key]
+	return value, ok
+}
+
+// MustGet returns the value stored by Set, it panics if the key does not exist.
+func (c *Context[T]) MustGet(key string) any {
+	value, ok := c.keys[key]
+	if !ok {
+		panic("nsxbot: key \"" + key + "\" does not exist")
+	}
+	return value
+}
+
+// Error adds err to the errors of the handler chain, they are passed to the error handlers of the engine
+// when the chain ends. It returns err.
+func (c *Context[T]) Error(err error) error {
+	c.errors = append(c.errors, err)
+	return err
+}
+
+// Errors returns the errors added by Error.
+func (c *Context[T]) Errors() []error {
+	return c.errors
+}
+
+// Block stops the event from reaching the handlers with a larger priority, see Composer.Priority.
+func (c *Context[T]) Block() {
+	c.blocked = true
+}
+
+// TraceId returns the trace id of the event being handled.
+func (c *Context[T]) TraceId() string {
+	return nlog.TraceId(c.Context)
+}
+
+// Route returns the name of the handler the context is dispatched to.
+func (c *Context[T]) Route() string {
+	return c.route
+}
+
+// Registration returns the registration of the handler, a handler can remove itself with it.
+func (c *Context[T]) Registration() *Registration {
+	return c.registration
+}
+
+// Bucket returns the bucket called name of the engine storage, see Engine.SetStorage.
+// It panics for a Context not dispatched by an engine, which has no storage.
+func (c *Context[T]) Bucket(name string) storage.Bucket {
+	if c.storage == nil {
+		panic("nsxbot: Context.Bucket without engine storage")
+	}
+	return c.storage.Bucket(name)
+}
@@ -2,70 +2,390 @@ package nsxbot
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/nsxdevx/nsxbot/event"
 	"github.com/nsxdevx/nsxbot/filter"
 )
 
+var ErrSessionLimit = errors.New("too many active sessions")
+
+// ErrSessionTimeout is the cause of the context of a session which reached its ttl, see WithSessionTTL.
+var ErrSessionTimeout = errors.New("session timeout")
+
 type SessionMsg interface {
 	event.Messager
 	SessionKey() string
 }
 
+// SessionScope decides which messages belong to the same session.
+type SessionScope int
+
+const (
+	// use the SessionKey of the message, per user in private chats and per group in groups
+	ScopeDefault SessionScope = iota
+	// per user in a group, per user in private chats
+	ScopeUserInGroup
+	// per group, everyone in the group shares the session
+	ScopeGroup
+	// per user across private and group chats
+	ScopeUser
+)
+
+func (s SessionScope) key(msg SessionMsg) string {
+	switch s {
+	case ScopeUserInGroup:
+		if m, ok := msg.(interface{ MemberSessionKey() string }); ok {
+			return m.MemberSessionKey()
+		}
+	case ScopeUser:
+		if m, ok := msg.(interface{ UserSessionKey() string }); ok {
+			return m.UserSessionKey()
+		}
+	}
+	return msg.SessionKey()
+}
+
 type Sation[T event.Messager] struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
 	sessionChan chan *Context[T]
+
+	// the chat counted by the session limit
+	chat string
+
+	mu      sync.Mutex
+	state   *SessionState
+	store   StateStore
+	resumed bool
 }
 
 // Context represents the context of a message in a session.
+// Await returns when the next message arrives, ctx is done or the session lifetime is reached.
 func (s *Sation[T]) Await(ctx context.Context, fillers ...filter.Filter[T]) (*Context[T], error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
 	case msg := <-s.sessionChan:
+		msg.Context = s.ctx
 		return msg, nil
 	}
 }
 
+// Deadline returns the time when the session will time out.
+func (s *Sation[T]) Deadline() (time.Time, bool) {
+	return s.ctx.Deadline()
+}
+
+// Resumed reports whether the session was restored from the state store,
+// the handler is then called with the message that the last Await was waiting for.
+func (s *Sation[T]) Resumed() bool {
+	return s.resumed
+}
+
+// Step returns the current step of the session.
+func (s *Sation[T]) Step() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Step
+}
+
+// Goto sets the current step and saves the session state.
+func (s *Sation[T]) Goto(step int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Step = step
+	return s.save()
+}
+
+// Store sets a json encoded value of the session and saves the session state.
+func (s *Sation[T]) Store(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.Data == nil {
+		s.state.Data = make(map[string]json.RawMessage)
+	}
+	s.state.Data[key] = data
+	return s.save()
+}
+
+// Load decodes the value of key into value, it returns false if the key is not set.
+func (s *Sation[T]) Load(key string, value any) (bool, error) {
+	s.mu.Lock()
+	data, ok := s.state.Data[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+func (s *Sation[T]) save() error {
+	s.state.UpdatedAt = time.Now()
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Save(s.ctx, s.state)
+}
+
+// restore loads the saved state of key, expired states are dropped.
+func (s *Sation[T]) restore(key string, store StateStore, ttl time.Duration) error {
+	s.store = store
+	s.state = &SessionState{Key: key}
+	if store == nil {
+		return nil
+	}
+	state, err := store.Load(s.ctx, key)
+	if errors.Is(err, ErrStateNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ttl > 0 && time.Since(state.UpdatedAt) > ttl {
+		return store.Delete(s.ctx, key)
+	}
+	s.state = state
+	s.resumed = true
+	return nil
+}
+
 type SessionStore[T event.Messager] struct {
 	mu       sync.Mutex
 	sessions map[string]*Sation[T]
+	// active sessions by chat
+	chats map[string]int
+	ttl   time.Duration
+	max   int
 }
 
-func (s *SessionStore[T]) Set(key string, ctx *Context[T]) (*Sation[T], bool) {
+// Set delivers ctx to the session of key, or creates a new session if there is none.
+// The limit of sessions applies to every chat on its own.
+func (s *SessionStore[T]) Set(key string, chat string, ctx *Context[T]) (*Sation[T], bool, error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.sessions[key]; ok {
-		s.sessions[key].sessionChan <- ctx
-		return s.sessions[key], false
-	} else {
-		s.sessions[key] = &Sation[T]{
+	sation, ok := s.sessions[key]
+	if !ok {
+		if s.max > 0 && s.chats[chat] >= s.max {
+			s.mu.Unlock()
+			return nil, false, ErrSessionLimit
+		}
+		sation = &Sation[T]{
 			sessionChan: make(chan *Context[T], 1),
+			chat:        chat,
+		}
+		if s.ttl > 0 {
+			sation.ctx, sation.cancel = context.WithTimeoutCause(ctx.Context, s.ttl, ErrSessionTimeout)
+		} else {
+			sation.ctx, sation.cancel = context.WithCancel(ctx.Context)
+		}
+		s.sessions[key] = sation
+		if s.chats == nil {
+			s.chats = make(map[string]int)
 		}
-		return s.sessions[key], true
+		s.chats[chat]++
+		s.mu.Unlock()
+		return sation, true, nil
 	}
+	s.mu.Unlock()
+	select {
+	case sation.sessionChan <- ctx:
+	case <-sation.ctx.Done():
+	}
+	return sation, false, nil
 }
 
 func (s *SessionStore[T]) Del(key string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	close(s.sessions[key].sessionChan)
-	delete(s.sessions, key)
+	if sation, ok := s.sessions[key]; ok {
+		sation.cancel()
+		delete(s.sessions, key)
+		if s.chats[sation.chat]--; s.chats[sation.chat] <= 0 {
+			delete(s.chats, sation.chat)
+		}
+	}
+}
+
+func (s *SessionStore[T]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// ChatLen returns the number of active sessions in chat.
+func (s *SessionStore[T]) ChatLen(chat string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chats[chat]
 }
 
 type SessionHandler[T event.Messager] = func(ctx *Context[T], sation *Sation[T])
 
+type SessionHook[T event.Messager] = func(ctx *Context[T])
+
+type conversation[T SessionMsg] struct {
+	scope     SessionScope
+	keyFunc   func(msg T) string
+	ttl       time.Duration
+	max       int
+	store     StateStore
+	onStart   SessionHook[T]
+	onEnd     SessionHook[T]
+	onTimeout SessionHook[T]
+}
+
+type ConversationOption[T SessionMsg] func(*conversation[T])
+
+// Set the session scope, default is ScopeDefault.
+func WithSessionScope[T SessionMsg](scope SessionScope) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.scope = scope
+	}
+}
+
+// Use a custom session key, it takes precedence over the scope.
+func WithSessionKey[T SessionMsg](keyFunc func(msg T) string) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.keyFunc = keyFunc
+	}
+}
+
+// Set the maximum lifetime of a session, the session times out after ttl even if it is still awaiting.
+func WithSessionTTL[T SessionMsg](ttl time.Duration) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.ttl = ttl
+	}
+}
+
+// Limit the number of concurrent sessions in every group or private chat, so a busy group cannot take all sessions.
+// Messages that would start a new session are ignored.
+// With ScopeUser a session counts for the chat it was started in.
+func WithMaxSessions[T SessionMsg](max int) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.max = max
+	}
+}
+
+// Persist the session step and data to store, so sessions survive a restart.
+// A saved session is resumed when the next message for its key arrives.
+func WithStateStore[T SessionMsg](store StateStore) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.store = store
+	}
+}
+
+// Called with the first message before the session handler.
+func OnSessionStart[T SessionMsg](hook SessionHook[T]) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.onStart = hook
+	}
+}
+
+// Called with the first message after the session handler returns.
+func OnSessionEnd[T SessionMsg](hook SessionHook[T]) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.onEnd = hook
+	}
+}
+
+// Called with the first message when the session reaches its ttl, before OnSessionEnd.
+func OnSessionTimeout[T SessionMsg](hook SessionHook[T]) ConversationOption[T] {
+	return func(c *conversation[T]) {
+		c.onTimeout = hook
+	}
+}
+
+func (c *conversation[T]) key(msg T) string {
+	if c.keyFunc != nil {
+		return c.keyFunc(msg)
+	}
+	return c.scope.key(msg)
+}
+
 // Start a conversation session with a handler.
-func NewConversation[T SessionMsg](handler SessionHandler[T]) HandlerFunc[T] {
+func NewConversation[T SessionMsg](handler SessionHandler[T], opts ...ConversationOption[T]) HandlerFunc[T] {
+	conv := &conversation[T]{}
+	for _, opt := range opts {
+		opt(conv)
+	}
 	store := &SessionStore[T]{
 		sessions: make(map[string]*Sation[T]),
+		ttl:      conv.ttl,
+		max:      conv.max,
 	}
 	return func(ctx *Context[T]) {
-		key := ctx.Msg.SessionKey()
-		sation, first := store.Set(key, ctx)
-		if first {
+		key := conv.key(ctx.Msg)
+		chat := ctx.Msg.SessionKey()
+		sation, first, err := store.Set(key, chat, ctx)
+		if err != nil {
+			ctx.Log.Warn("Session not started", "key", key, "chat", chat, "active", store.ChatLen(chat), "err", err)
+			return
+		}
+		if !first {
+			return
+		}
+		run := func(ctx *Context[T]) {
+			defer store.Del(key)
+			if err := sation.restore(key, conv.store, conv.ttl); err != nil {
+				ctx.Log.Error("Restore session state error", "key", key, "err", err)
+			}
+			parent := ctx.Context
+			ctx.Context = sation.ctx
+			if conv.onStart != nil {
+				conv.onStart(ctx)
+			}
 			handler(ctx, sation)
-			store.Del(key)
+			// hooks may still need to reply after the session timed out
+			ctx.Context = parent
+			// keep the state only if the engine is shutting down, the session is resumed after restart.
+			// A session ended by its ttl or the handler timeout is over.
+			if conv.store != nil && !errors.Is(context.Cause(sation.ctx), errShutdown) {
+				if err := conv.store.Delete(context.WithoutCancel(parent), key); err != nil {
+					ctx.Log.Error("Delete session state error", "key", key, "err", err)
+				}
+			}
+			// not the handler timeout, which ends the session too
+			if conv.onTimeout != nil && errors.Is(context.Cause(sation.ctx), ErrSessionTimeout) {
+				conv.onTimeout(ctx)
+			}
+			if conv.onEnd != nil {
+				conv.onEnd(ctx)
+			}
+		}
+		// a waiting session must not hold a worker of the pool
+		if ctx.detach != nil {
+			detached := *ctx
+			detached.keys = maps.Clone(ctx.keys)
+			// the errors added before are handled when the chain returns
+			detached.errors = nil
+			// the session keeps the handler context until it ends
+			cancel := ctx.cancel
+			ctx.cancel = nil
+			ctx.detach(func() {
+				if cancel != nil {
+					defer cancel()
+				}
+				defer func() {
+					if err := recover(); err != nil {
+						detached.recovered(err, debug.Stack())
+					}
+				}()
+				run(&detached)
+				detached.handleErrors()
+			})
+			return
 		}
+		run(ctx)
 	}
 }
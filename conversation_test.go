@@ -0,0 +1,123 @@
+package nsxbot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func groupMessage(groupId int64, userId int64) event.GroupMessage {
+	var msg event.GroupMessage
+	msg.GroupId = groupId
+	msg.UserId = userId
+	return msg
+}
+
+func TestSessionScope(t *testing.T) {
+	a, b := groupMessage(1, 10), groupMessage(1, 11)
+	assert.Equal(t, ScopeDefault.key(a), ScopeDefault.key(b))
+	assert.Equal(t, ScopeGroup.key(a), ScopeGroup.key(b))
+	assert.NotEqual(t, ScopeUserInGroup.key(a), ScopeUserInGroup.key(b))
+	assert.Equal(t, ScopeUser.key(a), ScopeUser.key(groupMessage(2, 10)))
+}
+
+func TestMaxSessionsPerChat(t *testing.T) {
+	store := &SessionStore[event.GroupMessage]{sessions: make(map[string]*Sation[event.GroupMessage]), max: 2}
+	set := func(msg event.GroupMessage) error {
+		ctx := NewContext(context.Background(), nil, 0, 0, msg, nil)
+		_, _, err := store.Set(ScopeUserInGroup.key(msg), msg.SessionKey(), &ctx)
+		return err
+	}
+	assert.NoError(t, set(groupMessage(1, 10)))
+	assert.NoError(t, set(groupMessage(1, 11)))
+	assert.ErrorIs(t, set(groupMessage(1, 12)), ErrSessionLimit)
+	// the busy group does not take the sessions of other groups
+	assert.NoError(t, set(groupMessage(2, 12)))
+	assert.Equal(t, 2, store.ChatLen(groupMessage(1, 0).SessionKey()))
+
+	store.Del(ScopeUserInGroup.key(groupMessage(1, 10)))
+	assert.NoError(t, set(groupMessage(1, 12)))
+	assert.Equal(t, 3, store.Len())
+}
+
+func TestSessionStateLifetime(t *testing.T) {
+	run := func(ctx context.Context, opts ...ConversationOption[event.GroupMessage]) *MemoryStateStore {
+		store := NewMemoryStateStore()
+		handler := NewConversation(func(ctx *Context[event.GroupMessage], sation *Sation[event.GroupMessage]) {
+			assert.NoError(t, sation.Goto(1))
+			_, err := sation.Await(ctx)
+			assert.Error(t, err)
+		}, append(opts, WithStateStore[event.GroupMessage](store))...)
+		nsxctx := NewContext(ctx, nil, 0, 0, groupMessage(1, 10), nil)
+		handler(&nsxctx)
+		return store
+	}
+	key := groupMessage(1, 10).SessionKey()
+
+	// a session reaching its ttl is over
+	store := run(context.Background(), WithSessionTTL[event.GroupMessage](20*time.Millisecond))
+	_, err := store.Load(context.Background(), key)
+	assert.ErrorIs(t, err, ErrStateNotFound)
+
+	// and so is a session whose handler timed out
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	store = run(ctx)
+	_, err = store.Load(context.Background(), key)
+	assert.ErrorIs(t, err, ErrStateNotFound)
+
+	// a session interrupted by the shutdown is resumed after restart
+	shutdownCtx, shutdown := context.WithCancelCause(context.Background())
+	time.AfterFunc(20*time.Millisecond, func() { shutdown(errShutdown) })
+	store = run(shutdownCtx)
+	state, err := store.Load(context.Background(), key)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, state.Step)
+}
+
+func TestSessionErrors(t *testing.T) {
+	e := New(&fakeListener{}, &fakeMux{})
+	var handled []error
+	e.OnError(func(herr *HandlerError) {
+		panic("broken error handler")
+	}, func(herr *HandlerError) {
+		handled = append(handled, herr.Errors...)
+		assert.Equal(t, int64(1), herr.GroupId)
+	})
+	failed := errors.New("failed")
+	handler := NewConversation(func(ctx *Context[event.GroupMessage], sation *Sation[event.GroupMessage]) {
+		ctx.Error(failed)
+	})
+	nsxctx := NewContext(context.Background(), nil, 0, 0, groupMessage(1, 10), nil)
+	nsxctx.engine = e
+	nsxctx.detach = e.detach
+	nsxctx.Log = e.log
+	handler(&nsxctx)
+	e.inflight.Wait()
+	assert.Equal(t, []error{failed}, handled)
+}
+
+func TestSessionTimeoutHook(t *testing.T) {
+	run := func(ctx context.Context, opts ...ConversationOption[event.GroupMessage]) bool {
+		var timedOut bool
+		handler := NewConversation(func(ctx *Context[event.GroupMessage], sation *Sation[event.GroupMessage]) {
+			_, err := sation.Await(ctx)
+			assert.Error(t, err)
+		}, append(opts, OnSessionTimeout(func(ctx *Context[event.GroupMessage]) {
+			timedOut = true
+		}))...)
+		nsxctx := NewContext(ctx, nil, 0, 0, groupMessage(1, 10), nil)
+		handler(&nsxctx)
+		return timedOut
+	}
+	assert.True(t, run(context.Background(), WithSessionTTL[event.GroupMessage](20*time.Millisecond)))
+
+	// the handler timeout is not the ttl of the session
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.False(t, run(ctx, WithSessionTTL[event.GroupMessage](time.Hour)))
+}
@@ -0,0 +1,277 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schedule interface {
+	// Next returns the next activation time after t, zero if there is none.
+	Next(t time.Time) time.Time
+}
+
+// Every activates at fixed intervals, aligned to the interval since the zero time.
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	d := time.Duration(e)
+	if d < time.Second {
+		d = time.Second
+	}
+	return t.Truncate(d).Add(d)
+}
+
+// Spec is a parsed cron expression, every field is a bitset of the allowed values.
+type Spec struct {
+	Second, Minute, Hour, Dom, Month, Dow uint64
+	// Location of the expression, nil means the location of the time passed to Next
+	Location *time.Location
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 6, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// star marks a field written as * or ?, used for the dom/dow matching rule
+const star = 1 << 63
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// Parse parses a standard 5 field cron expression (minute hour dom month dow),
+// an expression with a leading seconds field, a descriptor such as @daily or @every 1h30m.
+// The expression may start with CRON_TZ=<zone> or TZ=<zone> to set its time zone.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	var loc *time.Location
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		zone, rest, _ := strings.Cut(spec, " ")
+		_, name, _ := strings.Cut(zone, "=")
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("cron: invalid time zone %q: %w", name, err)
+		}
+		spec = strings.TrimSpace(rest)
+	}
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil {
+			return nil, fmt.Errorf("cron: invalid interval %q: %w", every, err)
+		}
+		return Every(d), nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		expr, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown descriptor %q", spec)
+		}
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields, got %d in %q", len(fields), spec)
+	}
+	s := &Spec{Location: loc}
+	var err error
+	for i, field := range []struct {
+		bits   *uint64
+		bounds bounds
+	}{
+		{&s.Second, seconds},
+		{&s.Minute, minutes},
+		{&s.Hour, hours},
+		{&s.Dom, doms},
+		{&s.Month, months},
+		{&s.Dow, dows},
+	} {
+		if *field.bits, err = parseField(fields[i], field.bounds); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		b, err := parseRange(part, b)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// parseRange parses *, ?, n, a-b, with an optional /step
+func parseRange(expr string, b bounds) (uint64, error) {
+	rng, stepExpr, hasStep := strings.Cut(expr, "/")
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+			return 0, fmt.Errorf("cron: invalid step %q", expr)
+		}
+	}
+	var start, end int
+	var extra uint64
+	switch {
+	case rng == "*" || rng == "?":
+		start, end = b.min, b.max
+		if !hasStep {
+			extra = star
+		}
+	default:
+		lo, hi, isRange := strings.Cut(rng, "-")
+		var err error
+		if start, err = parseValue(lo, b); err != nil {
+			return 0, err
+		}
+		end = start
+		if isRange {
+			if end, err = parseValue(hi, b); err != nil {
+				return 0, err
+			}
+		} else if hasStep {
+			end = b.max
+		}
+	}
+	// 7 is also sunday
+	if b.max == 6 && end == 7 {
+		if start == 7 {
+			start, end = 0, 0
+		} else {
+			end = 6
+			extra |= 1
+		}
+	}
+	if start < b.min || end > b.max || start > end {
+		return 0, fmt.Errorf("cron: %q out of range [%d, %d]", expr, b.min, b.max)
+	}
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits | extra, nil
+}
+
+func parseValue(expr string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(expr)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q", expr)
+	}
+	if b.max == 6 && v == 7 {
+		return v, nil
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("cron: %q out of range [%d, %d]", expr, b.min, b.max)
+	}
+	return v, nil
+}
+
+var errNoMatch = errors.New("cron: no matching time")
+
+// Next returns the next time after t matching the expression, in the location of the spec.
+func (s *Spec) Next(t time.Time) time.Time {
+	next, err := s.next(t)
+	if err != nil {
+		return time.Time{}
+	}
+	return next
+}
+
+func (s *Spec) next(t time.Time) (time.Time, error) {
+	origin := t.Location()
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+	loc := t.Location()
+	t = t.Truncate(time.Second).Add(time.Second)
+	limit := t.Year() + 5
+
+WRAP:
+	if t.Year() > limit {
+		return time.Time{}, errNoMatch
+	}
+	for !has(s.Month, int(t.Month())) {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for !has(s.Hour, t.Hour()) {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for !has(s.Minute, t.Minute()) {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	for !has(s.Second, t.Second()) {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+	if s.Location == nil {
+		return t.In(origin), nil
+	}
+	return t, nil
+}
+
+// dayMatches follows the cron rule: if both dom and dow are restricted, either may match.
+func (s *Spec) dayMatches(t time.Time) bool {
+	dom := has(s.Dom, t.Day())
+	dow := has(s.Dow, int(t.Weekday()))
+	if s.Dom&star != 0 || s.Dow&star != 0 {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
@@ -0,0 +1,72 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"@often",
+		"@every soon",
+		"CRON_TZ=Mars/Base * * * * *",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNext(t *testing.T) {
+	base := time.Date(2025, time.March, 14, 9, 30, 15, 0, time.UTC) // friday
+	tests := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"* * * * *", base, time.Date(2025, 3, 14, 9, 31, 0, 0, time.UTC)},
+		{"*/15 * * * * *", base, time.Date(2025, 3, 14, 9, 30, 30, 0, time.UTC)},
+		{"0 8 * * *", base, time.Date(2025, 3, 15, 8, 0, 0, 0, time.UTC)},
+		{"30 9-17/2 * * mon-fri", base, time.Date(2025, 3, 14, 11, 30, 0, 0, time.UTC)},
+		{"0 9 * * 7", base, time.Date(2025, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 0", base, time.Date(2025, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", base, time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", base, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// dom or dow when both are restricted
+		{"0 0 1 * mon", base, time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"@daily", base, time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", base, time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC)},
+		{"@monthly", base, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 1h", base, time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC)},
+		{"0 0 31 dec *", time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := Parse(test.spec)
+		if !assert.NoError(t, err, test.spec) {
+			continue
+		}
+		assert.Equal(t, test.expected, schedule.Next(test.from), test.spec)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	schedule, err := Parse("CRON_TZ=Asia/Shanghai 0 8 * * *")
+	assert.NoError(t, err)
+	next := schedule.Next(time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 3, 15, 8, 0, 0, 0, shanghai), next)
+	assert.True(t, next.Equal(time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)))
+}
@@ -0,0 +1,39 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	// the action returns at once and runs in the background
+	SuffixAsync = "_async"
+	// the action is queued by the rate limiter of the implementation
+	SuffixRateLimited = "_rate_limited"
+)
+
+// Call runs any action on emitter and decodes the data of the response into R,
+// failures are returned as ActionError like the built-in methods.
+func Call[P any, R any](ctx context.Context, emitter Emitter, action Action, params P) (*R, error) {
+	body, err := emitter.Raw(ctx, action, params)
+	if err != nil {
+		return nil, err
+	}
+	var resp Response[json.RawMessage]
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	return decodeResponse[R](action, resp, body)
+}
+
+// CallAsync runs action with the _async suffix, it does not wait for the action to be done.
+func CallAsync[P any](ctx context.Context, emitter Emitter, action Action, params P) error {
+	_, err := Call[P, json.RawMessage](ctx, emitter, action+SuffixAsync, params)
+	return err
+}
+
+// CallRateLimited runs action with the _rate_limited suffix, it does not wait for the action to be done.
+func CallRateLimited[P any](ctx context.Context, emitter Emitter, action Action, params P) error {
+	_, err := Call[P, json.RawMessage](ctx, emitter, action+SuffixRateLimited, params)
+	return err
+}
@@ -0,0 +1,32 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rawEmitter struct {
+	Emitter
+	action Action
+	body   string
+}
+
+func (e *rawEmitter) Raw(ctx context.Context, action Action, params any) ([]byte, error) {
+	e.action = action
+	return []byte(e.body), nil
+}
+
+func TestCall(t *testing.T) {
+	emitter := &rawEmitter{body: `{"status":"ok","retcode":0,"data":{"message_id":7},"echo":"1"}`}
+	res, err := Call[any, struct {
+		MessageId int `json:"message_id"`
+	}](context.Background(), emitter, "send_msg", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, res.MessageId)
+
+	emitter.body = `{"status":"async","retcode":1,"data":null,"echo":"2"}`
+	assert.NoError(t, CallAsync[any](context.Background(), emitter, "send_msg", nil))
+	assert.Equal(t, "send_msg_async", emitter.action)
+}
@@ -5,6 +5,7 @@ type Action = string
 const (
 	ACTION_SEND_PRIVATE_MSG        = "send_private_msg"
 	ACTION_SEND_GROUP_MSG          = "send_group_msg"
+	ACTION_SEND_MSG                = "send_msg"
 	ACTION_GET_MSG                 = "get_msg"
 	ACTION_DELETE_MSG              = "delete_msg"
 	ACTION_GET_LOGIN_INFO          = "get_login_info"
@@ -14,4 +15,8 @@ const (
 	ACTION_SET_FRIEND_ADD_REQUEST  = "set_friend_add_request"
 	ACTION_SET_GROUP_ADD_REQUEST   = "set_group_add_request"
 	ACTION_SET_GROUP_SPECIAL_TITLE = "set_group_special_title"
+
+	// extensions of go-cqhttp, NapCat and Lagrange
+	ACTION_SEND_GROUP_FORWARD_MSG   = "send_group_forward_msg"
+	ACTION_SEND_PRIVATE_FORWARD_MSG = "send_private_forward_msg"
 )
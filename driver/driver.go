@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/nlog"
 	"github.com/nsxdevx/nsxbot/schema"
 	"github.com/nsxdevx/nsxbot/types"
 	"github.com/tidwall/gjson"
@@ -48,11 +49,33 @@ type Request[T any] struct {
 
 type Response[T any] struct {
 	Status  string `json:"status"`
-	RetCode int    `json:"retCode"`
+	RetCode int    `json:"retcode"`
+	Msg     string `json:"msg,omitempty"`
+	// sent by some implementations instead of msg
+	Message string `json:"message,omitempty"`
+	Wording string `json:"wording,omitempty"`
 	Data    T      `json:"data,omitempty"`
 	Echo    string `json:"echo"`
 }
 
+// ErrMsg returns msg, or message if msg is empty.
+func (r Response[T]) ErrMsg() string {
+	if len(r.Msg) != 0 {
+		return r.Msg
+	}
+	return r.Message
+}
+
+// Async reports whether the action was accepted and runs asynchronously, it is not a failure.
+func (r Response[T]) Async() bool {
+	return r.RetCode == 1
+}
+
+// IsAsync reports whether body, a response returned by Emitter.Raw, accepted the action to run asynchronously.
+func IsAsync(body []byte) bool {
+	return gjson.GetBytes(body, "retcode").Int() == 1
+}
+
 func Onebot11ContentToEvent(content []byte) (event.Event, error) {
 	strContent := string(content)
 	postType := gjson.Get(strContent, "post_type")
@@ -72,6 +95,7 @@ func Onebot11ContentToEvent(content []byte) (event.Event, error) {
 	}
 
 	return event.Event{
+		TraceId: nlog.NewTraceId(),
 		Types:   []string{postType.String(), postType.String() + ":" + Type.String()},
 		RawData: content,
 		SelfId:  selfId.Int(),
@@ -0,0 +1,118 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Sentinel errors matched by ActionError with errors.Is.
+var (
+	// retcode 1400
+	ErrBadRequest = errors.New("bad request")
+	// retcode 1401 or 1403
+	ErrUnauthorized = errors.New("unauthorized")
+	// retcode 1404
+	ErrNotFound = errors.New("action not found")
+)
+
+// ActionError is returned when the OneBot implementation answered an action with a failure.
+// HTTP 4xx answers use 1000 + the status code as RetCode.
+type ActionError struct {
+	Action  string
+	Status  string
+	RetCode int
+	Msg     string
+	Wording string
+	// the raw response
+	Body []byte
+}
+
+func (e *ActionError) Error() string {
+	msg := e.Wording
+	if len(msg) == 0 {
+		msg = e.Msg
+	}
+	if len(msg) == 0 {
+		msg = string(e.Body)
+	}
+	return fmt.Sprintf("action %s failed, status: %s, retcode: %d, %s", e.Action, e.Status, e.RetCode, msg)
+}
+
+func (e *ActionError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.RetCode == 1400
+	case ErrUnauthorized:
+		return e.RetCode == 1401 || e.RetCode == 1403
+	case ErrNotFound:
+		return e.RetCode == 1404
+	}
+	return false
+}
+
+// TransportError is returned when the action did not reach the OneBot implementation or got no answer,
+// such as connection errors, HTTP 5xx answers and echo timeouts.
+type TransportError struct {
+	Action string
+	// the HTTP status code, 0 if there was no HTTP answer
+	StatusCode int
+	// the request was never sent, retrying it cannot duplicate the action
+	Unsent bool
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("action %s transport error, http status: %d", e.Action, e.StatusCode)
+	}
+	return fmt.Sprintf("action %s transport error: %v", e.Action, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Unsent reports whether err is a TransportError of a request which was never sent.
+func Unsent(err error) bool {
+	var transportErr *TransportError
+	return errors.As(err, &transportErr) && transportErr.Unsent
+}
+
+// dialError wraps an error of http.Client.Do, the request is unsent if the connection could not be established.
+func dialError(action string, err error) *TransportError {
+	var opErr *net.OpError
+	return &TransportError{Action: action, Unsent: errors.As(err, &opErr) && opErr.Op == "dial", Err: err}
+}
+
+// checkResponse returns an ActionError if resp is a failure,
+// retcode 1 is a success, the action was accepted and runs asynchronously.
+func checkResponse(action string, resp Response[json.RawMessage], body []byte) error {
+	if !strings.EqualFold("failed", resp.Status) && resp.RetCode <= 1 {
+		return nil
+	}
+	return &ActionError{
+		Action:  action,
+		Status:  resp.Status,
+		RetCode: resp.RetCode,
+		Msg:     resp.ErrMsg(),
+		Wording: resp.Wording,
+		Body:    body,
+	}
+}
+
+// decodeResponse checks the response and decodes its data.
+func decodeResponse[R any](action string, resp Response[json.RawMessage], body []byte) (*R, error) {
+	if err := checkResponse(action, resp, body); err != nil {
+		return nil, err
+	}
+	var res R
+	if len(resp.Data) != 0 && string(resp.Data) != "null" {
+		if err := json.Unmarshal(resp.Data, &res); err != nil {
+			return nil, err
+		}
+	}
+	return &res, nil
+}
@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	body := []byte(`{"status":"failed","retcode":1404,"msg":"no action","wording":"action not found","data":null,"echo":"1"}`)
+	var resp Response[json.RawMessage]
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	_, err := decodeResponse[struct{}]("foo", resp, body)
+
+	var actionErr *ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, 1404, actionErr.RetCode)
+	assert.Equal(t, "no action", actionErr.Msg)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrUnauthorized))
+
+	body = []byte(`{"status":"ok","retcode":0,"data":{"user_id":1},"echo":"2"}`)
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	res, err := decodeResponse[struct {
+		UserId int64 `json:"user_id"`
+	}]("foo", resp, body)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), res.UserId)
+}
+
+func TestCheckStatus(t *testing.T) {
+	err := checkStatus("foo", 403, nil)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	err = checkStatus("foo", 502, nil)
+	var transportErr *TransportError
+	assert.True(t, errors.As(err, &transportErr))
+	assert.Equal(t, 502, transportErr.StatusCode)
+	assert.NoError(t, checkStatus("foo", 200, nil))
+}
+
+func TestDecodeResponseMessageFallback(t *testing.T) {
+	body := []byte(`{"status":"failed","retcode":100,"message":"bad param","data":null,"echo":"1"}`)
+	var resp Response[json.RawMessage]
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	_, err := decodeResponse[struct{}]("foo", resp, body)
+
+	var actionErr *ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, "bad param", actionErr.Msg)
+}
+
+func TestCheckResponseAsync(t *testing.T) {
+	body := []byte(`{"status":"async","retcode":1,"data":null,"echo":"1"}`)
+	var resp Response[json.RawMessage]
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	assert.NoError(t, checkResponse("foo", resp, body))
+	assert.True(t, resp.Async())
+	assert.True(t, IsAsync(body))
+	assert.False(t, IsAsync([]byte(`{"status":"ok","retcode":0}`)))
+}
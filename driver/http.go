@@ -7,6 +7,7 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -28,8 +29,13 @@ type DriverHttp struct {
 }
 
 func NewDriverHttp(listenAddr string, emitterUrl ...string) *DriverHttp {
+	return NewDriverHttpWith(listenAddr, emitterUrl)
+}
+
+// NewDriverHttpWith is like NewDriverHttp with options for the emitters of emitterUrls.
+func NewDriverHttpWith(listenAddr string, emitterUrls []string, opts ...EmitterHttpOption) *DriverHttp {
 	return &DriverHttp{
-		EmitterMuxHttp: NewEmitterMuxHttp(emitterUrl...),
+		EmitterMuxHttp: NewEmitterMuxHttpWith(emitterUrls, opts...),
 		ListenerHttp:   NewListenerHttp(listenAddr),
 	}
 }
@@ -61,7 +67,7 @@ func NewListenerHttp(addr string, opts ...ListenerHttpOption) *ListenerHttp {
 		mux:          http.NewServeMux(),
 		addr:         addr,
 		replyTimeout: 1 * time.Second,
-		log:          nlog.Logger(),
+		log:          nlog.Component("driver"),
 	}
 	for _, opt := range opts {
 		opt(ListenerHttp)
@@ -82,16 +88,26 @@ func (l *ListenerHttp) Listen(ctx context.Context, eventChan chan<- event.Event)
 			l.log.Error("Invalid event", "err", err)
 			return
 		}
+		var replied context.Context
 		if slices.Contains(botevent.Types, event.EVENT_MESSAGE) || slices.Contains(botevent.Types, event.EVENT_NOTICE) {
-			ctx, cancel := context.WithTimeout(context.Background(), l.replyTimeout)
+			var cancel context.CancelFunc
+			replied, cancel = context.WithTimeout(context.Background(), l.replyTimeout)
+			defer cancel()
 			botevent.Replyer = &HttpReplyer{
 				Writer: w,
 				Cancel: cancel,
 			}
-			eventChan <- botevent
-			<-ctx.Done()
-		} else {
-			eventChan <- botevent
+		}
+		select {
+		case eventChan <- botevent:
+		case <-ctx.Done():
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if replied != nil {
+			<-replied.Done()
 		}
 
 	})
@@ -106,7 +122,10 @@ func (l *ListenerHttp) Listen(ctx context.Context, eventChan chan<- event.Event)
 			return
 		}
 	}()
-	return server.ListenAndServe()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func (l *ListenerHttp) auth(w http.ResponseWriter, r *http.Request) ([]byte, error) {
@@ -152,18 +171,23 @@ func NewEmitterMuxHttpSets(emitterhttps ...*EmitterHttp) *EmitterMuxHttp {
 	}
 	return &EmitterMuxHttp{
 		emitters: emitters,
-		log:      nlog.Logger(),
+		log:      nlog.Component("driver"),
 	}
 }
 
 func NewEmitterMuxHttp(urls ...string) *EmitterMuxHttp {
+	return NewEmitterMuxHttpWith(urls)
+}
+
+// NewEmitterMuxHttpWith is like NewEmitterMuxHttp with options for the emitters of urls.
+func NewEmitterMuxHttpWith(urls []string, opts ...EmitterHttpOption) *EmitterMuxHttp {
 	mux := &EmitterMuxHttp{
 		emitters: make(map[int64]Emitter),
-		log:      nlog.Logger(),
+		log:      nlog.Component("driver"),
 	}
 	for _, url := range urls {
 		go func() {
-			emitter := NewEmitterHttp(url)
+			emitter := NewEmitterHttp(url, opts...)
 			selfId, err := emitter.GetSelfId(context.Background())
 			if err != nil {
 				panic(err)
@@ -187,9 +211,23 @@ func (m *EmitterMuxHttp) AddEmitter(selfId int64, emitter Emitter) {
 }
 
 func (m *EmitterMuxHttp) RemoveEmitter(selfId int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	delete(m.emitters, selfId)
 }
 
+// Close releases the idle connections of the http emitters, see EmitterHttp.Close.
+func (m *EmitterMuxHttp) Close() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, emitter := range m.emitters {
+		if e, ok := emitter.(*EmitterHttp); ok {
+			e.Close()
+		}
+	}
+	return nil
+}
+
 func (m *EmitterMuxHttp) GetEmitter(selfId int64) (Emitter, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -202,19 +240,24 @@ func (m *EmitterMuxHttp) GetEmitter(selfId int64) (Emitter, error) {
 
 type EmitterHttp struct {
 	client *http.Client
-	url    string
-	token  string
-	selfId *int64
-	log    *slog.Logger
+	// the client was created by the emitter and is closed with it
+	ownClient bool
+	url       string
+	basePath  string
+	token     string
+	selfId    *int64
+	timeouts  actionTimeouts
+	log       *slog.Logger
 }
 
 type EmitterHttpOption func(*EmitterHttp)
 
 func NewEmitterHttp(url string, opts ...EmitterHttpOption) *EmitterHttp {
 	EmitterHttp := &EmitterHttp{
-		client: http.DefaultClient,
-		url:    url,
-		log:    nlog.Logger(),
+		client:    &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		ownClient: true,
+		url:       url,
+		log:       nlog.Component("driver"),
 	}
 	for _, opt := range opts {
 		opt(EmitterHttp)
@@ -235,12 +278,58 @@ func WithEmitterHttpToken(token string) EmitterHttpOption {
 	}
 }
 
-func (e *EmitterHttp) Raw(ctx context.Context, action Action, params any) ([]byte, error) {
+// Set the timeout of every action, default is no timeout besides the context of the call.
+func WithEmitterHttpTimeout(timeout time.Duration) EmitterHttpOption {
+	return func(e *EmitterHttp) {
+		e.timeouts.timeout = timeout
+	}
+}
+
+// Set the timeout of action, such as a longer timeout for file uploads.
+func WithEmitterHttpActionTimeout(action Action, timeout time.Duration) EmitterHttpOption {
+	return func(e *EmitterHttp) {
+		e.timeouts.set(action, timeout)
+	}
+}
+
+// Set the http client, to configure proxies, TLS or connection pooling by its transport,
+// default is a client of the emitter with the settings of http.DefaultTransport. The emitter does not close client.
+func WithEmitterHttpClient(client *http.Client) EmitterHttpOption {
+	return func(e *EmitterHttp) {
+		e.client = client
+		e.ownClient = false
+	}
+}
+
+// Set the path between url and the action name, such as "/onebot/v11".
+func WithEmitterHttpBasePath(basePath string) EmitterHttpOption {
+	return func(e *EmitterHttp) {
+		e.basePath = "/" + strings.Trim(basePath, "/")
+	}
+}
+
+// Close releases the idle connections of the client, unless it was set by WithEmitterHttpClient.
+func (e *EmitterHttp) Close() error {
+	if e.ownClient {
+		e.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (e *EmitterHttp) endpoint(action Action) string {
+	return strings.TrimSuffix(e.url, "/") + e.basePath + "/" + action
+}
+
+func (e *EmitterHttp) Raw(ctx context.Context, action Action, params any) (body []byte, err error) {
+	start := time.Now()
+	defer func() { observeAction(ctx, action, start, err) }()
 	reqbody, err := json.Marshal(params)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/"+action, bytes.NewBuffer(reqbody))
+	ctx, cancel := e.timeouts.context(ctx, action, 0)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint(action), bytes.NewBuffer(reqbody))
 	if err != nil {
 		return nil, err
 	}
@@ -248,59 +337,60 @@ func (e *EmitterHttp) Raw(ctx context.Context, action Action, params any) ([]byt
 	req.Header.Set("Authorization", "Bearer "+e.token)
 	res, err := e.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, dialError(action, err)
 	}
-	body, err := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	body, err = io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, &TransportError{Action: action, Err: err}
 	}
-	return body, nil
+	return body, checkStatus(action, res.StatusCode, body)
 }
 
 func (e *EmitterHttp) SendPvtMsg(ctx context.Context, userId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
-	return httpAction[types.SendPrivateMsgReq, types.SendMsgRes](ctx, e.client, e.token, e.url, ACTION_SEND_PRIVATE_MSG, types.SendPrivateMsgReq{
+	return httpAction[types.SendPrivateMsgReq, types.SendMsgRes](ctx, e, ACTION_SEND_PRIVATE_MSG, types.SendPrivateMsgReq{
 		UserId:  userId,
 		Message: msg,
 	})
 }
 
 func (e *EmitterHttp) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
-	return httpAction[types.SendGrMsgReq, types.SendMsgRes](ctx, e.client, e.token, e.url, ACTION_SEND_GROUP_MSG, types.SendGrMsgReq{
+	return httpAction[types.SendGrMsgReq, types.SendMsgRes](ctx, e, ACTION_SEND_GROUP_MSG, types.SendGrMsgReq{
 		GroupId: groupId,
 		Message: msg,
 	})
 }
 
 func (e *EmitterHttp) GetMsg(ctx context.Context, msgId int) (*types.GetMsgRes, error) {
-	return httpAction[types.GetMsgReq, types.GetMsgRes](ctx, e.client, e.token, e.url, ACTION_GET_MSG, types.GetMsgReq{
+	return httpAction[types.GetMsgReq, types.GetMsgRes](ctx, e, ACTION_GET_MSG, types.GetMsgReq{
 		MessageId: msgId,
 	})
 }
 
 func (e *EmitterHttp) DelMsg(ctx context.Context, messageId int) error {
-	_, err := httpAction[types.DelMsgReq, any](ctx, e.client, e.token, e.url, ACTION_DELETE_MSG, types.DelMsgReq{
+	_, err := httpAction[types.DelMsgReq, any](ctx, e, ACTION_DELETE_MSG, types.DelMsgReq{
 		MessageId: messageId,
 	})
 	return err
 }
 
 func (e *EmitterHttp) GetLoginInfo(ctx context.Context) (*types.LoginInfo, error) {
-	return httpAction[any, types.LoginInfo](ctx, e.client, e.token, e.url, ACTION_GET_LOGIN_INFO, nil)
+	return httpAction[any, types.LoginInfo](ctx, e, ACTION_GET_LOGIN_INFO, nil)
 }
 
 func (e *EmitterHttp) GetStrangerInfo(ctx context.Context, userId int64, noCache bool) (*types.StrangerInfo, error) {
-	return httpAction[types.GetStrangerInfo, types.StrangerInfo](ctx, e.client, e.token, e.url, ACTION_GET_STRANGER_INFO, types.GetStrangerInfo{
+	return httpAction[types.GetStrangerInfo, types.StrangerInfo](ctx, e, ACTION_GET_STRANGER_INFO, types.GetStrangerInfo{
 		UserId:  userId,
 		NoCache: noCache,
 	})
 }
 
 func (e *EmitterHttp) GetStatus(ctx context.Context) (*types.Status, error) {
-	return httpAction[any, types.Status](ctx, e.client, e.token, e.url, ACTION_GET_STATUS, nil)
+	return httpAction[any, types.Status](ctx, e, ACTION_GET_STATUS, nil)
 }
 
 func (e *EmitterHttp) GetVersionInfo(ctx context.Context) (*types.VersionInfo, error) {
-	return httpAction[any, types.VersionInfo](ctx, e.client, e.token, e.url, ACTION_GET_VERSION_INFO, nil)
+	return httpAction[any, types.VersionInfo](ctx, e, ACTION_GET_VERSION_INFO, nil)
 }
 
 func (e *EmitterHttp) GetSelfId(ctx context.Context) (int64, error) {
@@ -317,7 +407,7 @@ func (e *EmitterHttp) GetSelfId(ctx context.Context) (int64, error) {
 }
 
 func (e *EmitterHttp) SetFriendAddRequest(ctx context.Context, flag string, approve bool, remark string) error {
-	_, err := httpAction[types.FriendAddReq, any](ctx, e.client, e.token, e.url, ACTION_SET_FRIEND_ADD_REQUEST, types.FriendAddReq{
+	_, err := httpAction[types.FriendAddReq, any](ctx, e, ACTION_SET_FRIEND_ADD_REQUEST, types.FriendAddReq{
 		Flag:    flag,
 		Approve: approve,
 		Remark:  remark,
@@ -326,7 +416,7 @@ func (e *EmitterHttp) SetFriendAddRequest(ctx context.Context, flag string, appr
 }
 
 func (e *EmitterHttp) SetGroupAddRequest(ctx context.Context, flag string, approve bool, reason string) error {
-	_, err := httpAction[types.GroupAddReq, any](ctx, e.client, e.token, e.url, ACTION_SET_GROUP_ADD_REQUEST, types.GroupAddReq{
+	_, err := httpAction[types.GroupAddReq, any](ctx, e, ACTION_SET_GROUP_ADD_REQUEST, types.GroupAddReq{
 		Flag:    flag,
 		Approve: approve,
 		Reason:  reason,
@@ -335,7 +425,7 @@ func (e *EmitterHttp) SetGroupAddRequest(ctx context.Context, flag string, appro
 }
 
 func (e *EmitterHttp) SetGroupSpecialTitle(ctx context.Context, groupId int64, userId int64, specialTitle string, duration int) error {
-	_, err := httpAction[types.SpecialTitleReq, any](ctx, e.client, e.token, e.url, ACTION_SET_GROUP_SPECIAL_TITLE, types.SpecialTitleReq{
+	_, err := httpAction[types.SpecialTitleReq, any](ctx, e, ACTION_SET_GROUP_SPECIAL_TITLE, types.SpecialTitleReq{
 		GroupId:      groupId,
 		UserId:       userId,
 		SpecialTitle: specialTitle,
@@ -344,35 +434,54 @@ func (e *EmitterHttp) SetGroupSpecialTitle(ctx context.Context, groupId int64, u
 	return err
 }
 
-func httpAction[P any, R any](ctx context.Context, client *http.Client, token string, baseurl string, action string, params P) (*R, error) {
+func httpAction[P any, R any](ctx context.Context, e *EmitterHttp, action string, params P) (data *R, err error) {
+	start := time.Now()
+	defer func() { observeAction(ctx, action, start, err) }()
 	reqbody, err := json.Marshal(params)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseurl+"/"+action, bytes.NewBuffer(reqbody))
+	ctx, cancel := e.timeouts.context(ctx, action, 0)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint(action), bytes.NewBuffer(reqbody))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
-	res, err := client.Do(req)
+	req.Header.Set("Authorization", "Bearer "+e.token)
+	res, err := e.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, dialError(action, err)
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http status error code: %v", res.StatusCode)
-	}
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
+		return nil, &TransportError{Action: action, Err: err}
+	}
+	if err := checkStatus(action, res.StatusCode, body); err != nil {
 		return nil, err
 	}
-	var resp Response[R]
+	var resp Response[json.RawMessage]
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, err
 	}
-	if strings.EqualFold("failed", resp.Status) {
-		return nil, fmt.Errorf("action %s failed, rawdata: %s, please see onebot logs", action, string(body))
+	return decodeResponse[R](action, resp, body)
+}
+
+// checkStatus turns HTTP 4xx answers into ActionError and the other failures into TransportError.
+func checkStatus(action string, statusCode int, body []byte) error {
+	switch {
+	case statusCode == http.StatusOK:
+		return nil
+	case statusCode >= 400 && statusCode < 500:
+		return &ActionError{
+			Action:  action,
+			Status:  "failed",
+			RetCode: 1000 + statusCode,
+			Msg:     http.StatusText(statusCode),
+			Body:    body,
+		}
+	default:
+		return &TransportError{Action: action, StatusCode: statusCode, Err: fmt.Errorf("http status %d", statusCode)}
 	}
-	return &resp.Data, nil
 }
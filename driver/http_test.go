@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenerHttpStop(t *testing.T) {
+	l := NewListenerHttp("127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// nobody receives the events
+	events := make(chan event.Event)
+	go l.Listen(ctx, events)
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		body := `{"post_type":"message","message_type":"private","time":1,"self_id":1,"user_id":2,"message":[]}`
+		l.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		done <- w.Code
+	}()
+	cancel()
+	select {
+	case code := <-done:
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+	case <-time.After(time.Second):
+		t.Fatal("handler blocked after the listener stopped")
+	}
+}
+
+func TestEmitterMuxHttpOptions(t *testing.T) {
+	tokens := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get("Authorization")
+		w.Write([]byte(`{"status":"ok","retcode":0,"data":{}}`))
+	}))
+	defer srv.Close()
+	mux := NewEmitterMuxHttpWith([]string{srv.URL}, WithEmitterHttpSelfId(1), WithEmitterHttpToken("secret"))
+	assert.Equal(t, "Bearer secret", <-tokens)
+	assert.Eventually(t, func() bool {
+		_, err := mux.GetEmitter(1)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, mux.Close())
+
+	// a client set by option belongs to the caller
+	client := &http.Client{}
+	assert.False(t, NewEmitterHttp(srv.URL, WithEmitterHttpClient(client)).ownClient)
+}
@@ -0,0 +1,247 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/metrics"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+	"github.com/tidwall/gjson"
+)
+
+var sendsVetoed = metrics.Default.Counter("nsxbot_sends_vetoed_total",
+	"Messages vetoed by an interceptor, by action.", "action")
+
+// ErrVetoed is wrapped by the errors of interceptors refusing to send a message, see Veto.
+var ErrVetoed = errors.New("send vetoed")
+
+// Veto returns an error wrapping ErrVetoed for an interceptor refusing to send a message.
+func Veto(reason string) error {
+	return fmt.Errorf("%w: %s", ErrVetoed, reason)
+}
+
+// Outgoing is a message sent by SendGrMsg, SendPvtMsg, a send action or a quick reply,
+// interceptors can change it before calling next.
+type Outgoing struct {
+	// ACTION_SEND_GROUP_MSG or ACTION_SEND_PRIVATE_MSG, also for send_msg and quick replies.
+	// ACTION_SEND_GROUP_FORWARD_MSG or ACTION_SEND_PRIVATE_FORWARD_MSG for merged-forward messages, whose Message has node segments.
+	Action  Action
+	SelfId  int64
+	GroupId int64
+	UserId  int64
+	Message schema.MessageChain
+	// the intercepted emitter, for other actions
+	Emitter Emitter
+}
+
+// SendFunc sends an outgoing message.
+type SendFunc func(ctx context.Context, out *Outgoing) (*types.SendMsgRes, error)
+
+// Interceptor wraps the sending of messages, it can rewrite out and call next, or veto the message by returning
+// an error without calling next.
+type Interceptor func(ctx context.Context, out *Outgoing, next SendFunc) (*types.SendMsgRes, error)
+
+// Chain combines interceptors, the first one is the outermost.
+func Chain(interceptors ...Interceptor) Interceptor {
+	return func(ctx context.Context, out *Outgoing, next SendFunc) (*types.SendMsgRes, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, out *Outgoing) (*types.SendMsgRes, error) {
+				return interceptor(ctx, out, inner)
+			}
+		}
+		return next(ctx, out)
+	}
+}
+
+// Intercepted is an Emitter passing the messages of SendGrMsg, SendPvtMsg, the send message actions of Raw and Call,
+// and the replies of quick operations through interceptors, see Intercepted.Replyer.
+type Intercepted struct {
+	Emitter
+	selfId      int64
+	interceptor Interceptor
+}
+
+func NewIntercepted(emitter Emitter, selfId int64, interceptors ...Interceptor) *Intercepted {
+	return &Intercepted{Emitter: emitter, selfId: selfId, interceptor: Chain(interceptors...)}
+}
+
+func (i *Intercepted) send(ctx context.Context, out *Outgoing, send SendFunc) (*types.SendMsgRes, error) {
+	res, err := i.interceptor(ctx, out, send)
+	if errors.Is(err, ErrVetoed) {
+		sendsVetoed.Inc(out.Action)
+	}
+	return res, err
+}
+
+type forwardParams struct {
+	GroupId  int64               `json:"group_id,omitempty"`
+	UserId   int64               `json:"user_id,omitempty"`
+	Messages schema.MessageChain `json:"messages"`
+}
+
+func (i *Intercepted) sendMsg(ctx context.Context, out *Outgoing) (*types.SendMsgRes, error) {
+	switch out.Action {
+	case ACTION_SEND_GROUP_MSG:
+		return i.Emitter.SendGrMsg(ctx, out.GroupId, out.Message)
+	case ACTION_SEND_GROUP_FORWARD_MSG:
+		return Call[forwardParams, types.SendMsgRes](ctx, i.Emitter, out.Action, forwardParams{GroupId: out.GroupId, Messages: out.Message})
+	case ACTION_SEND_PRIVATE_FORWARD_MSG:
+		return Call[forwardParams, types.SendMsgRes](ctx, i.Emitter, out.Action, forwardParams{UserId: out.UserId, Messages: out.Message})
+	}
+	return i.Emitter.SendPvtMsg(ctx, out.UserId, out.Message)
+}
+
+// sendAction returns the param holding the message of the send action, false for other actions.
+func sendAction(action Action) (string, bool) {
+	switch action {
+	case ACTION_SEND_GROUP_MSG, ACTION_SEND_PRIVATE_MSG, ACTION_SEND_MSG:
+		return "message", true
+	case ACTION_SEND_GROUP_FORWARD_MSG, ACTION_SEND_PRIVATE_FORWARD_MSG:
+		return "messages", true
+	}
+	return "", false
+}
+
+func (i *Intercepted) SendPvtMsg(ctx context.Context, userId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return i.send(ctx, &Outgoing{Action: ACTION_SEND_PRIVATE_MSG, SelfId: i.selfId, UserId: userId, Message: msg, Emitter: i.Emitter}, i.sendMsg)
+}
+
+func (i *Intercepted) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return i.send(ctx, &Outgoing{Action: ACTION_SEND_GROUP_MSG, SelfId: i.selfId, GroupId: groupId, Message: msg, Emitter: i.Emitter}, i.sendMsg)
+}
+
+// Raw intercepts the actions sending messages, including merged-forward messages, the other actions are sent as they are.
+func (i *Intercepted) Raw(ctx context.Context, action Action, params any) ([]byte, error) {
+	key, ok := sendAction(action)
+	if !ok {
+		return i.Emitter.Raw(ctx, action, params)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	out := &Outgoing{
+		Action:  ACTION_SEND_PRIVATE_MSG,
+		SelfId:  i.selfId,
+		GroupId: gjson.GetBytes(data, "group_id").Int(),
+		UserId:  gjson.GetBytes(data, "user_id").Int(),
+		Message: parseMessage(fields[key]),
+		Emitter: i.Emitter,
+	}
+	messageType := gjson.GetBytes(data, "message_type").String()
+	switch {
+	case action == ACTION_SEND_GROUP_MSG || (action == ACTION_SEND_MSG && (messageType == "group" || (len(messageType) == 0 && out.GroupId != 0))):
+		out.Action = ACTION_SEND_GROUP_MSG
+	case action == ACTION_SEND_GROUP_FORWARD_MSG || action == ACTION_SEND_PRIVATE_FORWARD_MSG:
+		out.Action = action
+	}
+	sent := *out
+	var body []byte
+	_, err = i.send(ctx, out, func(ctx context.Context, out *Outgoing) (*types.SendMsgRes, error) {
+		if !reflect.DeepEqual(out.Message, sent.Message) {
+			fields[key], _ = json.Marshal(out.Message)
+		}
+		if out.GroupId != sent.GroupId {
+			fields["group_id"], _ = json.Marshal(out.GroupId)
+		}
+		if out.UserId != sent.UserId {
+			fields["user_id"], _ = json.Marshal(out.UserId)
+		}
+		var err error
+		if body, err = i.Emitter.Raw(ctx, action, fields); err != nil {
+			return nil, err
+		}
+		var resp Response[types.SendMsgRes]
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		return &resp.Data, nil
+	})
+	return body, err
+}
+
+// parseMessage reads the message param of an action or a quick reply, a string is plain text.
+func parseMessage(raw json.RawMessage) schema.MessageChain {
+	var msg schema.MessageChain
+	var text string
+	var seg schema.Message
+	switch {
+	case json.Unmarshal(raw, &text) == nil:
+		return msg.Text(text)
+	case json.Unmarshal(raw, &seg) == nil && len(seg.Type) != 0:
+		return schema.MessageChain{seg}
+	}
+	json.Unmarshal(raw, &msg)
+	return msg
+}
+
+// Replyer returns replyer with the replies of quick operations on the message event content passed through
+// the interceptors, which get ctx. A quick reply always goes to the chat of the event, changing it has no effect.
+// The quick operation answers one message, further messages and merged-forward messages are sent by the emitter.
+// Other events and quick operations without reply are not intercepted.
+func (i *Intercepted) Replyer(ctx context.Context, replyer event.Replyer, content []byte) event.Replyer {
+	if gjson.GetBytes(content, "post_type").String() != event.EVENT_MESSAGE {
+		return replyer
+	}
+	out := Outgoing{Action: ACTION_SEND_PRIVATE_MSG, SelfId: i.selfId, UserId: gjson.GetBytes(content, "user_id").Int(), Emitter: i.Emitter}
+	if gjson.GetBytes(content, "message_type").String() == "group" {
+		out = Outgoing{Action: ACTION_SEND_GROUP_MSG, SelfId: i.selfId, GroupId: gjson.GetBytes(content, "group_id").Int(), Emitter: i.Emitter}
+	}
+	return &interceptedReplyer{Replyer: replyer, ctx: ctx, intercepted: i, out: out}
+}
+
+type interceptedReplyer struct {
+	event.Replyer
+	ctx         context.Context
+	intercepted *Intercepted
+	out         Outgoing
+}
+
+func (r *interceptedReplyer) Reply(data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields["reply"] == nil {
+		return r.Replyer.Reply(data)
+	}
+	out := r.out
+	out.Message = parseMessage(fields["reply"])
+	reply := out.Message
+	// a quick operation is answered once, an interceptor sending several messages or a merged-forward
+	// message, such as LongMessages, sends the others by the emitter
+	var replied bool
+	_, err = r.intercepted.send(r.ctx, &out, func(ctx context.Context, out *Outgoing) (*types.SendMsgRes, error) {
+		if replied || out.Action != r.out.Action {
+			return r.intercepted.sendMsg(ctx, out)
+		}
+		replied = true
+		if !reflect.DeepEqual(out.Message, reply) {
+			fields["reply"], _ = json.Marshal(out.Message)
+		}
+		// quick operations answer no message id
+		return nil, r.Replyer.Reply(fields)
+	})
+	if replied || err != nil {
+		return err
+	}
+	// the reply was sent by the emitter, keep the other operations
+	for _, key := range []string{"reply", "auto_escape", "at_sender"} {
+		delete(fields, key)
+	}
+	if len(fields) != 0 {
+		return r.Replyer.Reply(fields)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntercepted(t *testing.T) {
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, out *Outgoing, next SendFunc) (*types.SendMsgRes, error) {
+			order = append(order, name)
+			out.Message = out.Message.Text(name)
+			return next(ctx, out)
+		}
+	}
+	emitter := &recordEmitter{}
+	i := NewIntercepted(emitter, 1, trace("a"), trace("b"))
+	_, err := i.SendGrMsg(context.Background(), 2, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, order)
+	assert.Equal(t, []int64{2}, emitter.groups)
+
+	veto := func(ctx context.Context, out *Outgoing, next SendFunc) (*types.SendMsgRes, error) {
+		if out.GroupId == 3 {
+			return nil, Veto("blacklisted")
+		}
+		return next(ctx, out)
+	}
+	i = NewIntercepted(emitter, 1, veto)
+	_, err = i.SendGrMsg(context.Background(), 3, schema.MessageChain{}.Text("hi"))
+	assert.True(t, errors.Is(err, ErrVetoed))
+	assert.Equal(t, []int64{2}, emitter.groups)
+}
+
+type sendActionEmitter struct {
+	Emitter
+	params []map[string]json.RawMessage
+}
+
+func (e *sendActionEmitter) Raw(ctx context.Context, action Action, params any) ([]byte, error) {
+	e.params = append(e.params, params.(map[string]json.RawMessage))
+	return []byte(`{"status":"ok","retcode":0,"data":{"message_id":7}}`), nil
+}
+
+type recordReplyer struct {
+	replies []any
+}
+
+func (r *recordReplyer) Reply(data any) error {
+	r.replies = append(r.replies, data)
+	return nil
+}
+
+func TestInterceptedRawAndReplies(t *testing.T) {
+	var outs []Outgoing
+	interceptor := func(ctx context.Context, out *Outgoing, next SendFunc) (*types.SendMsgRes, error) {
+		outs = append(outs, *out)
+		if out.Message.PlainText() == "secret" {
+			return nil, Veto("secret")
+		}
+		out.Message = out.Message.Text("!")
+		return next(ctx, out)
+	}
+	emitter := &sendActionEmitter{}
+	i := NewIntercepted(emitter, 1, interceptor)
+
+	res, err := Call[map[string]any, types.SendMsgRes](context.Background(), i, ACTION_SEND_MSG,
+		map[string]any{"message_type": "group", "group_id": 2, "message": "hi"})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, res.MessageId)
+	assert.Equal(t, ACTION_SEND_GROUP_MSG, outs[0].Action)
+	assert.Equal(t, int64(2), outs[0].GroupId)
+	assert.Equal(t, "hi!", parseMessage(emitter.params[0]["message"]).PlainText())
+
+	// other actions are not intercepted
+	_, err = i.Raw(context.Background(), ACTION_DELETE_MSG, map[string]json.RawMessage{})
+	assert.NoError(t, err)
+	assert.Len(t, outs, 1)
+
+	replyer := &recordReplyer{}
+	content := []byte(`{"post_type":"message","message_type":"private","user_id":3}`)
+	quick := i.Replyer(context.Background(), replyer, content)
+	assert.NoError(t, event.CommonMessage{}.Reply(quick, "pong"))
+	assert.Equal(t, ACTION_SEND_PRIVATE_MSG, outs[1].Action)
+	assert.Equal(t, int64(3), outs[1].UserId)
+	reply := replyer.replies[0].(map[string]json.RawMessage)["reply"]
+	assert.Equal(t, "pong!", parseMessage(reply).PlainText())
+
+	err = event.CommonMessage{}.Reply(quick, "secret")
+	assert.ErrorIs(t, err, ErrVetoed)
+	assert.Len(t, replyer.replies, 1)
+
+	// quick operations without reply are not messages
+	request := i.Replyer(context.Background(), replyer, []byte(`{"post_type":"request","request_type":"friend"}`))
+	assert.Same(t, replyer, request)
+}
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/metrics"
+	"github.com/nsxdevx/nsxbot/nlog"
+)
+
+var (
+	actionTotal = metrics.Default.Counter("nsxbot_emitter_actions_total",
+		"Emitter actions by action name and result (ok, error, timeout).", "action", "result")
+	actionDuration = metrics.Default.Histogram("nsxbot_emitter_action_duration_seconds",
+		"Emitter action latency.", nil, "action")
+	echoWait = metrics.Default.Histogram("nsxbot_ws_echo_wait_seconds",
+		"Time waiting for the echo of a websocket action.", nil, "action")
+	wsReconnects = metrics.Default.Counter("nsxbot_ws_reconnects_total",
+		"Websocket client reconnects by url.", "url")
+	wsConnections = metrics.Default.Counter("nsxbot_ws_connections_total",
+		"Websocket server accepted connections by listen address.", "addr")
+)
+
+var actionLog = nlog.Component("driver")
+
+// observeAction records the action metrics and logs it with the trace id of ctx.
+func observeAction(ctx context.Context, action string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	actionDuration.Observe(elapsed.Seconds(), action)
+	switch {
+	case err == nil:
+		actionTotal.Inc(action, "ok")
+	case errors.Is(err, context.DeadlineExceeded):
+		actionTotal.Inc(action, "timeout")
+	default:
+		actionTotal.Inc(action, "error")
+	}
+	log := actionLog
+	if traceId := nlog.TraceId(ctx); len(traceId) != 0 {
+		log = log.With("trace", traceId)
+	}
+	if err != nil {
+		log.Warn("Action failed", "action", action, "elapsed", elapsed, "err", err)
+		return
+	}
+	log.Debug("Action", "action", action, "elapsed", elapsed)
+}
@@ -0,0 +1,363 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/metrics"
+	"github.com/nsxdevx/nsxbot/nlog"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+)
+
+var (
+	actionRetries = metrics.Default.Counter("nsxbot_emitter_retries_total",
+		"Actions retried by the resilient emitter.", "action")
+	actionFailovers = metrics.Default.Counter("nsxbot_emitter_failovers_total",
+		"Actions sent to a fallback emitter.", "action")
+	circuitOpens = metrics.Default.Counter("nsxbot_emitter_circuit_opens_total",
+		"Circuits opened after consecutive transport errors, by self id.", "self_id")
+)
+
+// ErrCircuitOpen is returned when the circuits of all emitters are open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// breaker is a circuit breaker counting consecutive transport errors.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	// a call is trying the half-open circuit
+	probing bool
+}
+
+func (b *breaker) allow(threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if threshold <= 0 || b.failures < threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record returns true if the circuit was opened.
+func (b *breaker) record(err error, threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		// the backend answered
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if threshold > 0 && b.failures >= threshold {
+		b.openUntil = time.Now().Add(cooldown)
+		return b.failures == threshold
+	}
+	return false
+}
+
+// cancel ends the probe of a call canceled by its caller, which tells nothing about the emitter.
+func (b *breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+type ResilientOption func(*Resilient)
+
+// Retry idempotent actions up to retries times, waiting backoff before the first retry and doubling it up to maxBackoff.
+func WithResilientRetry(retries int, backoff time.Duration, maxBackoff time.Duration) ResilientOption {
+	return func(r *Resilient) {
+		r.retries = retries
+		r.backoff = backoff
+		r.maxBackoff = maxBackoff
+	}
+}
+
+// Also retry sends and other actions changing state after backoff, only when the request was never sent (see Unsent),
+// so a message is never delivered twice. Without it they only fail over to the fallbacks when unsent.
+func WithResilientRetrySends() ResilientOption {
+	return func(r *Resilient) {
+		r.retrySends = true
+	}
+}
+
+// Open the circuit of an emitter after threshold consecutive transport errors, calls skip it for cooldown.
+// 0 threshold disables the circuit breaker.
+func WithResilientBreaker(threshold int, cooldown time.Duration) ResilientOption {
+	return func(r *Resilient) {
+		r.threshold = threshold
+		r.cooldown = cooldown
+	}
+}
+
+// Add emitters of the same bot used when the previous emitters fail, in order.
+func WithResilientFallback(fallbacks ...Emitter) ResilientOption {
+	return func(r *Resilient) {
+		for _, fallback := range fallbacks {
+			r.emitters = append(r.emitters, &resilientEmitter{Emitter: fallback})
+		}
+	}
+}
+
+// Decide which actions are idempotent, default are actions starting with get_ or can_.
+func WithResilientIdempotent(idempotent func(action Action) bool) ResilientOption {
+	return func(r *Resilient) {
+		r.idempotent = idempotent
+	}
+}
+
+type resilientEmitter struct {
+	Emitter
+	breaker breaker
+}
+
+// Resilient is an Emitter retrying failed actions, with a circuit breaker and fallback emitters for every emitter.
+// Only transport errors are retried, an ActionError is returned at once.
+type Resilient struct {
+	emitters   []*resilientEmitter
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	retrySends bool
+	threshold  int
+	cooldown   time.Duration
+	idempotent func(action Action) bool
+	selfId     string
+	log        *slog.Logger
+}
+
+func NewResilient(primary Emitter, opts ...ResilientOption) *Resilient {
+	r := &Resilient{
+		emitters:   []*resilientEmitter{{Emitter: primary}},
+		retries:    3,
+		backoff:    200 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+		threshold:  5,
+		cooldown:   30 * time.Second,
+		idempotent: func(action Action) bool {
+			return strings.HasPrefix(action, "get_") || strings.HasPrefix(action, "can_")
+		},
+		log: nlog.Component("driver"),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// resilientCall runs fn on the emitters of r until it succeeds, fails with an error which must not be retried,
+// or the retries are used up.
+func resilientCall[R any](ctx context.Context, r *Resilient, action Action, fn func(e Emitter) (R, error)) (res R, err error) {
+	idempotent := r.idempotent(action)
+	backoff := r.backoff
+	err = ErrCircuitOpen
+	for attempt := 0; ; attempt++ {
+		for i, e := range r.emitters {
+			if !e.breaker.allow(r.threshold) {
+				continue
+			}
+			if i > 0 {
+				actionFailovers.Inc(action)
+			}
+			res, err = fn(e)
+			if err != nil && ctx.Err() != nil {
+				// canceled by the caller, not a failure of the emitter
+				e.breaker.cancel()
+				return res, err
+			}
+			if e.breaker.record(err, r.threshold, r.cooldown) {
+				circuitOpens.Inc(r.selfId)
+				r.log.Warn("Circuit open", "selfId", r.selfId, "emitter", i, "cooldown", r.cooldown, "err", err)
+			}
+			var transportErr *TransportError
+			if err == nil || !errors.As(err, &transportErr) {
+				return res, err
+			}
+			if !idempotent && !Unsent(err) {
+				// the action may have been done, sending it again could duplicate it
+				return res, err
+			}
+		}
+		if attempt >= r.retries || (!idempotent && !r.retrySends) {
+			return res, err
+		}
+		actionRetries.Inc(action)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, r.maxBackoff)
+	}
+}
+
+func (r *Resilient) SendPvtMsg(ctx context.Context, userId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return resilientCall(ctx, r, ACTION_SEND_PRIVATE_MSG, func(e Emitter) (*types.SendMsgRes, error) {
+		return e.SendPvtMsg(ctx, userId, msg)
+	})
+}
+
+func (r *Resilient) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return resilientCall(ctx, r, ACTION_SEND_GROUP_MSG, func(e Emitter) (*types.SendMsgRes, error) {
+		return e.SendGrMsg(ctx, groupId, msg)
+	})
+}
+
+func (r *Resilient) GetMsg(ctx context.Context, msgId int) (*types.GetMsgRes, error) {
+	return resilientCall(ctx, r, ACTION_GET_MSG, func(e Emitter) (*types.GetMsgRes, error) {
+		return e.GetMsg(ctx, msgId)
+	})
+}
+
+func (r *Resilient) DelMsg(ctx context.Context, msgId int) error {
+	_, err := resilientCall(ctx, r, ACTION_DELETE_MSG, func(e Emitter) (any, error) {
+		return nil, e.DelMsg(ctx, msgId)
+	})
+	return err
+}
+
+func (r *Resilient) GetLoginInfo(ctx context.Context) (*types.LoginInfo, error) {
+	return resilientCall(ctx, r, ACTION_GET_LOGIN_INFO, func(e Emitter) (*types.LoginInfo, error) {
+		return e.GetLoginInfo(ctx)
+	})
+}
+
+func (r *Resilient) GetStrangerInfo(ctx context.Context, userId int64, noCache bool) (*types.StrangerInfo, error) {
+	return resilientCall(ctx, r, ACTION_GET_STRANGER_INFO, func(e Emitter) (*types.StrangerInfo, error) {
+		return e.GetStrangerInfo(ctx, userId, noCache)
+	})
+}
+
+func (r *Resilient) GetStatus(ctx context.Context) (*types.Status, error) {
+	return resilientCall(ctx, r, ACTION_GET_STATUS, func(e Emitter) (*types.Status, error) {
+		return e.GetStatus(ctx)
+	})
+}
+
+func (r *Resilient) GetVersionInfo(ctx context.Context) (*types.VersionInfo, error) {
+	return resilientCall(ctx, r, ACTION_GET_VERSION_INFO, func(e Emitter) (*types.VersionInfo, error) {
+		return e.GetVersionInfo(ctx)
+	})
+}
+
+func (r *Resilient) GetSelfId(ctx context.Context) (int64, error) {
+	return r.emitters[0].GetSelfId(ctx)
+}
+
+func (r *Resilient) SetFriendAddRequest(ctx context.Context, flag string, approve bool, remark string) error {
+	_, err := resilientCall(ctx, r, ACTION_SET_FRIEND_ADD_REQUEST, func(e Emitter) (any, error) {
+		return nil, e.SetFriendAddRequest(ctx, flag, approve, remark)
+	})
+	return err
+}
+
+func (r *Resilient) SetGroupAddRequest(ctx context.Context, flag string, approve bool, reason string) error {
+	_, err := resilientCall(ctx, r, ACTION_SET_GROUP_ADD_REQUEST, func(e Emitter) (any, error) {
+		return nil, e.SetGroupAddRequest(ctx, flag, approve, reason)
+	})
+	return err
+}
+
+func (r *Resilient) SetGroupSpecialTitle(ctx context.Context, groupId int64, userId int64, specialTitle string, duration int) error {
+	_, err := resilientCall(ctx, r, ACTION_SET_GROUP_SPECIAL_TITLE, func(e Emitter) (any, error) {
+		return nil, e.SetGroupSpecialTitle(ctx, groupId, userId, specialTitle, duration)
+	})
+	return err
+}
+
+func (r *Resilient) Raw(ctx context.Context, action Action, params any) ([]byte, error) {
+	return resilientCall(ctx, r, action, func(e Emitter) ([]byte, error) {
+		return e.Raw(ctx, action, params)
+	})
+}
+
+// FailoverMux gets the emitters of a bot from several muxes, the emitter of the first mux is the primary one
+// and the others are its fallbacks, such as an http emitter for a dead websocket connection.
+type FailoverMux struct {
+	muxes []EmitterMux
+	opts  []ResilientOption
+	mu    sync.Mutex
+	cache map[int64]*failoverEntry
+}
+
+type failoverEntry struct {
+	emitters  []Emitter
+	resilient *Resilient
+}
+
+func NewFailoverMux(muxes []EmitterMux, opts ...ResilientOption) *FailoverMux {
+	return &FailoverMux{
+		muxes: muxes,
+		opts:  opts,
+		cache: make(map[int64]*failoverEntry),
+	}
+}
+
+// AddEmitter adds emitter to the first mux.
+func (m *FailoverMux) AddEmitter(selfId int64, emitter Emitter) {
+	m.muxes[0].AddEmitter(selfId, emitter)
+}
+
+func (m *FailoverMux) GetEmitter(selfId int64) (Emitter, error) {
+	var emitters []Emitter
+	var lastErr error
+	for _, mux := range m.muxes {
+		emitter, err := mux.GetEmitter(selfId)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		emitters = append(emitters, emitter)
+	}
+	if len(emitters) == 0 {
+		return nil, lastErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// keep the circuit state while the emitters of the bot do not change, a reconnect creates new emitters
+	if entry, ok := m.cache[selfId]; ok && equalEmitters(entry.emitters, emitters) {
+		return entry.resilient, nil
+	}
+	opts := append([]ResilientOption{WithResilientFallback(emitters[1:]...)}, m.opts...)
+	entry := &failoverEntry{emitters: emitters, resilient: NewResilient(emitters[0], opts...)}
+	entry.resilient.selfId = strconv.FormatInt(selfId, 10)
+	m.cache[selfId] = entry
+	return entry.resilient, nil
+}
+
+func equalEmitters(a, b []Emitter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameEmitter(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameEmitter compares emitters without panicking on values of non-comparable types, which are never the same.
+func sameEmitter(a, b Emitter) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		return !va.IsValid() && !vb.IsValid()
+	}
+	return va.Type() == vb.Type() && va.Comparable() && va.Equal(vb)
+}
@@ -0,0 +1,132 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyEmitter struct {
+	Emitter
+	calls int
+	err   error
+}
+
+func (e *flakyEmitter) GetStatus(ctx context.Context) (*types.Status, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &types.Status{}, nil
+}
+
+func (e *flakyEmitter) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &types.SendMsgRes{}, nil
+}
+
+func TestResilientRetry(t *testing.T) {
+	primary := &flakyEmitter{err: &TransportError{Action: ACTION_GET_STATUS, Err: errors.New("eof")}}
+	r := NewResilient(primary, WithResilientRetry(2, time.Millisecond, time.Millisecond), WithResilientBreaker(0, 0))
+	_, err := r.GetStatus(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 3, primary.calls)
+
+	// an answer of the backend is not retried
+	primary = &flakyEmitter{err: &ActionError{Action: ACTION_GET_STATUS, RetCode: 1400}}
+	r = NewResilient(primary, WithResilientRetry(2, time.Millisecond, time.Millisecond))
+	_, err = r.GetStatus(context.Background())
+	assert.True(t, errors.Is(err, ErrBadRequest))
+	assert.Equal(t, 1, primary.calls)
+}
+
+func TestResilientSendFailover(t *testing.T) {
+	// a send which may have been delivered is neither retried nor sent by the fallback
+	primary := &flakyEmitter{err: &TransportError{Action: ACTION_SEND_GROUP_MSG, Err: context.DeadlineExceeded}}
+	fallback := &flakyEmitter{}
+	r := NewResilient(primary, WithResilientFallback(fallback), WithResilientRetry(2, time.Millisecond, time.Millisecond))
+	_, err := r.SendGrMsg(context.Background(), 1, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, primary.calls)
+	assert.Equal(t, 0, fallback.calls)
+
+	primary.err = &TransportError{Action: ACTION_SEND_GROUP_MSG, Unsent: true, Err: errors.New("closed")}
+	_, err = r.SendGrMsg(context.Background(), 1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fallback.calls)
+}
+
+func TestResilientBreaker(t *testing.T) {
+	primary := &flakyEmitter{err: &TransportError{Action: ACTION_GET_STATUS, Err: errors.New("eof")}}
+	fallback := &flakyEmitter{}
+	r := NewResilient(primary, WithResilientFallback(fallback), WithResilientBreaker(2, time.Hour))
+	for range 4 {
+		_, err := r.GetStatus(context.Background())
+		assert.NoError(t, err)
+	}
+	// the circuit of the primary is open after 2 failures
+	assert.Equal(t, 2, primary.calls)
+	assert.Equal(t, 4, fallback.calls)
+}
+
+func TestResilientBreakerCanceledProbe(t *testing.T) {
+	primary := &flakyEmitter{err: &TransportError{Action: ACTION_GET_STATUS, Err: errors.New("eof")}}
+	r := NewResilient(primary, WithResilientBreaker(1, 10*time.Millisecond), WithResilientRetry(0, 0, 0))
+	_, err := r.GetStatus(context.Background())
+	assert.Error(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	// the half-open probe is canceled by its caller
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.GetStatus(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 2, primary.calls)
+
+	// the next call probes again instead of finding the circuit open forever
+	primary.err = nil
+	_, err = r.GetStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, primary.calls)
+}
+
+// valueEmitter is not comparable, two values are never the same emitter.
+type valueEmitter struct {
+	Emitter
+	tags []string
+}
+
+type staticMux struct {
+	emitter Emitter
+}
+
+func (m *staticMux) GetEmitter(selfId int64) (Emitter, error) {
+	return m.emitter, nil
+}
+
+func (m *staticMux) AddEmitter(selfId int64, emitter Emitter) {}
+
+func TestFailoverMuxCache(t *testing.T) {
+	primary := &flakyEmitter{}
+	mux := NewFailoverMux([]EmitterMux{&staticMux{emitter: primary}, &staticMux{emitter: valueEmitter{tags: []string{"http"}}}})
+	_, err := mux.GetEmitter(1)
+	assert.NoError(t, err)
+	assert.NotPanics(t, func() {
+		_, err = mux.GetEmitter(1)
+	})
+	assert.NoError(t, err)
+
+	// the circuit state is kept while the emitters do not change
+	mux = NewFailoverMux([]EmitterMux{&staticMux{emitter: primary}})
+	first, _ := mux.GetEmitter(1)
+	second, _ := mux.GetEmitter(1)
+	assert.Same(t, first, second)
+}
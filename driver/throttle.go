@@ -0,0 +1,486 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/metrics"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	sendQueueLength = metrics.Default.Gauge("nsxbot_send_queue_length",
+		"Messages waiting in the send queue, by self id and priority.", "self_id", "priority")
+	sendWait = metrics.Default.Histogram("nsxbot_send_wait_seconds",
+		"Time a message waited in the send queue.", []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60}, "self_id")
+	sendRejected = metrics.Default.Counter("nsxbot_send_rejected_total",
+		"Messages rejected because the send queue was full, by self id.", "self_id")
+)
+
+// ErrSendQueueFull is returned by a throttled emitter with WithThrottleReject when its send queue is full.
+var ErrSendQueueFull = errors.New("send queue full")
+
+// SendPriority is the lane of a message in the send queue, the messages of higher lanes are sent first.
+type SendPriority int
+
+const (
+	// messages sent by jobs
+	PriorityBroadcast SendPriority = iota
+	// messages sent with a context without priority
+	PriorityNormal
+	// messages sent by handlers
+	PriorityReply
+)
+
+func (p SendPriority) String() string {
+	switch p {
+	case PriorityBroadcast:
+		return "broadcast"
+	case PriorityReply:
+		return "reply"
+	}
+	return "normal"
+}
+
+type sendPriorityKey struct{}
+
+// WithSendPriority sets the priority of the messages sent with ctx.
+func WithSendPriority(ctx context.Context, priority SendPriority) context.Context {
+	return context.WithValue(ctx, sendPriorityKey{}, priority)
+}
+
+// SendPriorityFrom returns the priority set by WithSendPriority, PriorityNormal by default.
+func SendPriorityFrom(ctx context.Context) SendPriority {
+	if priority, ok := ctx.Value(sendPriorityKey{}).(SendPriority); ok && priority >= PriorityBroadcast && priority <= PriorityReply {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// bucket is a token bucket, a rate <= 0 is unlimited.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = b.burst
+	} else {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// wait returns how long to wait for a token.
+func (b *bucket) wait(now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) take(now time.Time) {
+	if b.rate > 0 {
+		b.refill(now)
+		b.tokens--
+	}
+}
+
+func (b *bucket) full(now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+type ThrottleOption func(*throttleConfig)
+
+type throttleConfig struct {
+	rate        float64
+	burst       int
+	targetRate  float64
+	targetBurst int
+	jitter      time.Duration
+	limit       int
+	reject      bool
+}
+
+// Limit the messages of a bot to rate per second with bursts of burst messages, a rate <= 0 is unlimited.
+func WithThrottleRate(rate float64, burst int) ThrottleOption {
+	return func(c *throttleConfig) {
+		c.rate = rate
+		c.burst = max(burst, 1)
+	}
+}
+
+// Limit the messages of a bot to one group or user to rate per second with bursts of burst messages,
+// a rate <= 0 is unlimited.
+func WithThrottleTargetRate(rate float64, burst int) ThrottleOption {
+	return func(c *throttleConfig) {
+		c.targetRate = rate
+		c.targetBurst = max(burst, 1)
+	}
+}
+
+// Wait a random duration up to jitter before every message, 0 disables the jitter.
+func WithThrottleJitter(jitter time.Duration) ThrottleOption {
+	return func(c *throttleConfig) {
+		c.jitter = jitter
+	}
+}
+
+// Limit the send queue of a bot to limit messages, senders wait for space when it is full. 0 is unlimited.
+func WithThrottleQueueLimit(limit int) ThrottleOption {
+	return func(c *throttleConfig) {
+		c.limit = limit
+	}
+}
+
+// Return ErrSendQueueFull at once instead of waiting for space when the send queue is full.
+func WithThrottleReject() ThrottleOption {
+	return func(c *throttleConfig) {
+		c.reject = true
+	}
+}
+
+func newThrottleConfig(opts []ThrottleOption) *throttleConfig {
+	c := &throttleConfig{
+		rate:        1,
+		burst:       5,
+		targetRate:  0.5,
+		targetBurst: 3,
+		jitter:      500 * time.Millisecond,
+		limit:       200,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type sendResult struct {
+	res *types.SendMsgRes
+	err error
+}
+
+type sendTask struct {
+	ctx      context.Context
+	target   string
+	priority SendPriority
+	queued   time.Time
+	send     func(ctx context.Context) (*types.SendMsgRes, error)
+	done     chan sendResult
+}
+
+// sendQueue is the send queue of a bot, a single goroutine sends its messages while it is not empty.
+type sendQueue struct {
+	cfg     *throttleConfig
+	selfId  string
+	mu      sync.Mutex
+	lanes   [PriorityReply + 1][]*sendTask
+	length  int
+	running bool
+	// closed and replaced when a message leaves the queue
+	space   chan struct{}
+	wake    chan struct{}
+	global  bucket
+	targets map[string]*bucket
+}
+
+func newSendQueue(cfg *throttleConfig, selfId string) *sendQueue {
+	return &sendQueue{
+		cfg:     cfg,
+		selfId:  selfId,
+		space:   make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		global:  bucket{rate: cfg.rate, burst: float64(cfg.burst)},
+		targets: make(map[string]*bucket),
+	}
+}
+
+func (q *sendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.length
+}
+
+func (q *sendQueue) saturated() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.cfg.limit > 0 && q.length >= q.cfg.limit
+}
+
+// enqueue waits until the message was sent.
+func (q *sendQueue) enqueue(ctx context.Context, target string, send func(ctx context.Context) (*types.SendMsgRes, error)) (*types.SendMsgRes, error) {
+	task := &sendTask{
+		ctx:      ctx,
+		target:   target,
+		priority: SendPriorityFrom(ctx),
+		send:     send,
+		done:     make(chan sendResult, 1),
+	}
+	q.mu.Lock()
+	for q.cfg.limit > 0 && q.length >= q.cfg.limit {
+		if q.cfg.reject {
+			q.mu.Unlock()
+			sendRejected.Inc(q.selfId)
+			return nil, ErrSendQueueFull
+		}
+		space := q.space
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-space:
+		}
+		q.mu.Lock()
+	}
+	task.queued = time.Now()
+	q.lanes[task.priority] = append(q.lanes[task.priority], task)
+	q.length++
+	sendQueueLength.Add(1, q.selfId, task.priority.String())
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case result := <-task.done:
+		return result.res, result.err
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	for i, queued := range q.lanes[task.priority] {
+		if queued == task {
+			q.remove(task.priority, i)
+			q.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	q.mu.Unlock()
+	// already being sent
+	result := <-task.done
+	return result.res, result.err
+}
+
+// remove must be called with mu held.
+func (q *sendQueue) remove(priority SendPriority, i int) *sendTask {
+	task := q.lanes[priority][i]
+	q.lanes[priority] = slices.Delete(q.lanes[priority], i, i+1)
+	q.length--
+	sendQueueLength.Add(-1, q.selfId, priority.String())
+	close(q.space)
+	q.space = make(chan struct{})
+	return task
+}
+
+func (q *sendQueue) target(key string, now time.Time) *bucket {
+	b, ok := q.targets[key]
+	if ok {
+		return b
+	}
+	if len(q.targets) >= 1024 {
+		for key, b := range q.targets {
+			if b.full(now) {
+				delete(q.targets, key)
+			}
+		}
+	}
+	b = &bucket{rate: q.cfg.targetRate, burst: float64(q.cfg.targetBurst)}
+	q.targets[key] = b
+	return b
+}
+
+// next removes the first message of the highest lane whose target has a token, otherwise it returns how long
+// to wait for one. Canceled messages are dropped. It must be called with mu held.
+func (q *sendQueue) next(now time.Time) (*sendTask, time.Duration) {
+	wait := time.Duration(-1)
+	for priority := PriorityReply; priority >= PriorityBroadcast; priority-- {
+		for i := 0; i < len(q.lanes[priority]); i++ {
+			task := q.lanes[priority][i]
+			if err := task.ctx.Err(); err != nil {
+				q.remove(priority, i)
+				task.done <- sendResult{err: err}
+				i--
+				continue
+			}
+			d := q.target(task.target, now).wait(now)
+			if d == 0 {
+				return q.remove(priority, i), 0
+			}
+			if wait < 0 || d < wait {
+				wait = d
+			}
+		}
+	}
+	return nil, wait
+}
+
+func (q *sendQueue) run() {
+	for {
+		q.mu.Lock()
+		if q.length == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		var task *sendTask
+		wait := q.global.wait(now)
+		if wait == 0 {
+			task, wait = q.next(now)
+		}
+		if task != nil {
+			q.global.take(now)
+			q.target(task.target, now).take(now)
+		}
+		q.mu.Unlock()
+		if task == nil {
+			if wait < 0 {
+				// only canceled messages were left
+				continue
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-q.wake:
+				timer.Stop()
+			}
+			continue
+		}
+		if q.cfg.jitter > 0 {
+			timer := time.NewTimer(rand.N(q.cfg.jitter))
+			select {
+			case <-task.ctx.Done():
+				timer.Stop()
+				task.done <- sendResult{err: task.ctx.Err()}
+				continue
+			case <-timer.C:
+			}
+		}
+		sendWait.Since(task.queued, q.selfId)
+		res, err := task.send(task.ctx)
+		task.done <- sendResult{res: res, err: err}
+	}
+}
+
+// Throttled is an Emitter sending SendGrMsg, SendPvtMsg and the actions of Raw sending messages, such as merged-forward
+// messages, through the send queue of its bot, so the bot does not send faster than the limits. The other actions are not queued.
+type Throttled struct {
+	Emitter
+	queue *sendQueue
+}
+
+// NewThrottled throttles the messages of a single emitter, see NewThrottledMux to throttle every bot of a mux.
+func NewThrottled(emitter Emitter, opts ...ThrottleOption) *Throttled {
+	return &Throttled{Emitter: emitter, queue: newSendQueue(newThrottleConfig(opts), "")}
+}
+
+func (t *Throttled) SendPvtMsg(ctx context.Context, userId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return t.queue.enqueue(ctx, "u"+strconv.FormatInt(userId, 10), func(ctx context.Context) (*types.SendMsgRes, error) {
+		return t.Emitter.SendPvtMsg(ctx, userId, msg)
+	})
+}
+
+func (t *Throttled) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return t.queue.enqueue(ctx, "g"+strconv.FormatInt(groupId, 10), func(ctx context.Context) (*types.SendMsgRes, error) {
+		return t.Emitter.SendGrMsg(ctx, groupId, msg)
+	})
+}
+
+func (t *Throttled) Raw(ctx context.Context, action Action, params any) ([]byte, error) {
+	if _, ok := sendAction(action); !ok {
+		return t.Emitter.Raw(ctx, action, params)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	target := "u" + gjson.GetBytes(data, "user_id").String()
+	groupId := gjson.GetBytes(data, "group_id")
+	switch action {
+	case ACTION_SEND_GROUP_MSG, ACTION_SEND_GROUP_FORWARD_MSG:
+		target = "g" + groupId.String()
+	case ACTION_SEND_MSG:
+		if groupId.Exists() && gjson.GetBytes(data, "message_type").String() != "private" {
+			target = "g" + groupId.String()
+		}
+	}
+	var body []byte
+	_, err = t.queue.enqueue(ctx, target, func(ctx context.Context) (*types.SendMsgRes, error) {
+		var err error
+		body, err = t.Emitter.Raw(ctx, action, params)
+		return nil, err
+	})
+	return body, err
+}
+
+// Len returns the number of messages in the send queue of the bot.
+func (t *Throttled) Len() int {
+	return t.queue.Len()
+}
+
+// Saturated reports whether the send queue of the bot is full, a handler can skip optional messages then.
+func (t *Throttled) Saturated() bool {
+	return t.queue.saturated()
+}
+
+// ThrottledMux gives every bot of a mux its own send queue, kept across reconnects.
+type ThrottledMux struct {
+	mux    EmitterMux
+	cfg    *throttleConfig
+	mu     sync.Mutex
+	queues map[int64]*sendQueue
+}
+
+func NewThrottledMux(mux EmitterMux, opts ...ThrottleOption) *ThrottledMux {
+	return &ThrottledMux{
+		mux:    mux,
+		cfg:    newThrottleConfig(opts),
+		queues: make(map[int64]*sendQueue),
+	}
+}
+
+func (m *ThrottledMux) AddEmitter(selfId int64, emitter Emitter) {
+	m.mux.AddEmitter(selfId, emitter)
+}
+
+func (m *ThrottledMux) GetEmitter(selfId int64) (Emitter, error) {
+	emitter, err := m.mux.GetEmitter(selfId)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queue, ok := m.queues[selfId]
+	if !ok {
+		queue = newSendQueue(m.cfg, strconv.FormatInt(selfId, 10))
+		m.queues[selfId] = queue
+	}
+	return &Throttled{Emitter: emitter, queue: queue}, nil
+}
+
+// Unwrap returns the throttled mux.
+func (m *ThrottledMux) Unwrap() EmitterMux {
+	return m.mux
+}
@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordEmitter struct {
+	Emitter
+	mu     sync.Mutex
+	groups []int64
+}
+
+func (e *recordEmitter) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.groups = append(e.groups, groupId)
+	return &types.SendMsgRes{}, nil
+}
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := bucket{rate: 2, burst: 2}
+	assert.Zero(t, b.wait(now))
+	b.take(now)
+	b.take(now)
+	assert.Equal(t, 500*time.Millisecond, b.wait(now))
+	assert.Zero(t, b.wait(now.Add(500*time.Millisecond)))
+	assert.False(t, b.full(now.Add(500*time.Millisecond)))
+	assert.True(t, b.full(now.Add(time.Second)))
+}
+
+func TestThrottledPriority(t *testing.T) {
+	emitter := &recordEmitter{}
+	th := NewThrottled(emitter, WithThrottleRate(2, 1), WithThrottleTargetRate(0, 0), WithThrottleJitter(0))
+	// the first message takes the only token, the others queue up
+	_, err := th.SendGrMsg(context.Background(), 0, nil)
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for i, priority := range []SendPriority{PriorityBroadcast, PriorityNormal, PriorityReply} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := th.SendGrMsg(WithSendPriority(context.Background(), priority), int64(i+1), nil)
+			assert.NoError(t, err)
+		}()
+		assert.Eventually(t, func() bool { return th.Len() == i+1 }, time.Second, time.Millisecond)
+	}
+	wg.Wait()
+	assert.Equal(t, []int64{0, 3, 2, 1}, emitter.groups)
+}
+
+func TestThrottledQueueLimit(t *testing.T) {
+	emitter := &recordEmitter{}
+	th := NewThrottled(emitter, WithThrottleRate(1, 1), WithThrottleJitter(0), WithThrottleQueueLimit(1), WithThrottleReject())
+	_, err := th.SendGrMsg(context.Background(), 1, nil)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := th.SendGrMsg(ctx, 2, nil)
+		done <- err
+	}()
+	assert.Eventually(t, th.Saturated, time.Second, time.Millisecond)
+	_, err = th.SendGrMsg(context.Background(), 3, nil)
+	assert.ErrorIs(t, err, ErrSendQueueFull)
+
+	// a canceled message leaves the queue without being sent
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Zero(t, th.Len())
+	assert.Equal(t, []int64{1}, emitter.groups)
+}
+
+func TestThrottledRaw(t *testing.T) {
+	emitter := &recordEmitter{}
+	th := NewThrottled(emitter, WithThrottleRate(1, 1), WithThrottleJitter(0), WithThrottleQueueLimit(1), WithThrottleReject())
+	// the first message takes the only token
+	_, err := th.SendGrMsg(context.Background(), 1, nil)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := Call[forwardParams, types.SendMsgRes](ctx, th, ACTION_SEND_GROUP_FORWARD_MSG, forwardParams{GroupId: 2})
+		done <- err
+	}()
+	// merged-forward messages wait in the queue
+	assert.Eventually(t, th.Saturated, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Zero(t, th.Len())
+	assert.Equal(t, []int64{1}, emitter.groups)
+}
@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// actionTimeouts holds the action timeout of an emitter and the overrides of single actions.
+type actionTimeouts struct {
+	timeout time.Duration
+	actions map[Action]time.Duration
+}
+
+func (t *actionTimeouts) set(action Action, timeout time.Duration) {
+	if t.actions == nil {
+		t.actions = make(map[Action]time.Duration)
+	}
+	t.actions[action] = timeout
+}
+
+// get returns the timeout of action, an action with the _async or _rate_limited suffix uses the timeout of the action.
+func (t *actionTimeouts) get(action Action, def time.Duration) time.Duration {
+	for _, name := range []Action{action, strings.TrimSuffix(action, SuffixAsync), strings.TrimSuffix(action, SuffixRateLimited)} {
+		if timeout, ok := t.actions[name]; ok {
+			return timeout
+		}
+	}
+	if t.timeout > 0 {
+		return t.timeout
+	}
+	return def
+}
+
+// context limits ctx to the timeout of action, a timeout <= 0 does not limit ctx.
+func (t *actionTimeouts) context(ctx context.Context, action Action, def time.Duration) (context.Context, context.CancelFunc) {
+	if timeout := t.get(action, def); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
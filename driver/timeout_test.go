@@ -0,0 +1,19 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionTimeouts(t *testing.T) {
+	var timeouts actionTimeouts
+	assert.Equal(t, 5*time.Second, timeouts.get(ACTION_GET_MSG, 5*time.Second))
+
+	timeouts.timeout = time.Second
+	timeouts.set("upload_group_file", time.Minute)
+	assert.Equal(t, time.Second, timeouts.get(ACTION_GET_MSG, 5*time.Second))
+	assert.Equal(t, time.Minute, timeouts.get("upload_group_file", 5*time.Second))
+	assert.Equal(t, time.Minute, timeouts.get("upload_group_file_async", 5*time.Second))
+}
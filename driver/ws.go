@@ -3,6 +3,7 @@ package driver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -21,7 +22,7 @@ import (
 	"github.com/tidwall/gjson"
 )
 
-// ws onebot await echo message time out
+// ws onebot await echo message time out, the default of WithEmitterWSTimeout
 var EchoTimeOut = 5 * time.Second
 
 type echoStore struct {
@@ -59,6 +60,8 @@ func (e *echoStore) Get(selfId int64) chan Response[json.RawMessage] {
 type WSnode struct {
 	Url   string
 	Token string
+	// options of the emitters of this node
+	EmitterOptions []EmitterWSOption
 }
 
 type WSClient struct {
@@ -74,13 +77,13 @@ func NewWSClient(retryDelay time.Duration, nodes ...WSnode) *WSClient {
 		WSEmittersMux: &WSEmittersMux{
 			emitters:         make(map[int64]Emitter),
 			connectCallbacks: make(map[int64]func(Emitter)),
-			log:              nlog.Logger(),
+			log:              nlog.Component("driver"),
 		},
 		echoStore: &echoStore{
 			echos: make(map[int64]chan Response[json.RawMessage]),
 		},
 		nodes:      nodes,
-		log:        nlog.Logger(),
+		log:        nlog.Component("driver"),
 		retryDelay: retryDelay,
 	}
 }
@@ -89,6 +92,7 @@ func (ws *WSClient) Listen(ctx context.Context, eventChan chan<- event.Event) er
 	for _, node := range ws.nodes {
 		go func(ctx context.Context) {
 			ticker := time.NewTicker(ws.retryDelay)
+			connected := false
 			for {
 				select {
 				case <-ctx.Done():
@@ -101,6 +105,10 @@ func (ws *WSClient) Listen(ctx context.Context, eventChan chan<- event.Event) er
 						ws.log.Error("Dial", "err", err)
 						continue
 					}
+					if connected {
+						wsReconnects.Inc(node.Url)
+					}
+					connected = true
 					defer func() {
 						if err := c.Close(); err != nil {
 							ws.log.Error("Close", "err", err)
@@ -129,7 +137,7 @@ func (ws *WSClient) Listen(ctx context.Context, eventChan chan<- event.Event) er
 								return
 							}
 
-							emitter := NewEmitterWS(botevent.SelfId, c, ws.echoStore.Get(botevent.SelfId))
+							emitter := NewEmitterWS(botevent.SelfId, c, ws.echoStore.Get(botevent.SelfId), node.EmitterOptions...)
 
 							if slices.Contains(botevent.Types, event.EVENT_META) {
 								connSelfId = botevent.SelfId
@@ -142,7 +150,10 @@ func (ws *WSClient) Listen(ctx context.Context, eventChan chan<- event.Event) er
 									emitter: emitter,
 								}
 							}
-							eventChan <- botevent
+							select {
+							case eventChan <- botevent:
+							case <-ctx.Done():
+							}
 						}()
 					}
 				}
@@ -155,10 +166,11 @@ func (ws *WSClient) Listen(ctx context.Context, eventChan chan<- event.Event) er
 
 type WServer struct {
 	*WSEmittersMux
-	echoStore *echoStore
-	url       url.URL
-	token     string
-	log       *slog.Logger
+	echoStore      *echoStore
+	url            url.URL
+	token          string
+	emitterOptions []EmitterWSOption
+	log            *slog.Logger
 }
 
 type WServerOption func(*WServer)
@@ -169,12 +181,19 @@ func WSerevrWithToken(token string) WServerOption {
 	}
 }
 
+// Set the options of the emitters of the connected bots.
+func WSerevrWithEmitterOptions(opts ...EmitterWSOption) WServerOption {
+	return func(ws *WServer) {
+		ws.emitterOptions = append(ws.emitterOptions, opts...)
+	}
+}
+
 func NewWSverver(host string, path string, opts ...WServerOption) *WServer {
 	ws := &WServer{
 		WSEmittersMux: &WSEmittersMux{
 			emitters:         make(map[int64]Emitter),
 			connectCallbacks: make(map[int64]func(Emitter)),
-			log:              nlog.Logger(),
+			log:              nlog.Component("driver"),
 		},
 		echoStore: &echoStore{
 			echos: make(map[int64]chan Response[json.RawMessage]),
@@ -184,7 +203,7 @@ func NewWSverver(host string, path string, opts ...WServerOption) *WServer {
 			Host:   host,
 			Path:   path,
 		},
-		log: nlog.Logger(),
+		log: nlog.Component("driver"),
 	}
 	for _, opt := range opts {
 		opt(ws)
@@ -205,6 +224,7 @@ func (ws *WServer) Listen(ctx context.Context, eventChan chan<- event.Event) err
 			ws.log.Error("Upgrade", "err", err)
 			return
 		}
+		wsConnections.Inc(ws.url.Host)
 		defer func() {
 			if err := c.Close(); err != nil {
 				ws.log.Error("Close", "err", err)
@@ -234,7 +254,7 @@ func (ws *WServer) Listen(ctx context.Context, eventChan chan<- event.Event) err
 					return
 				}
 
-				emitter := NewEmitterWS(botevent.SelfId, c, ws.echoStore.Get(botevent.SelfId))
+				emitter := NewEmitterWS(botevent.SelfId, c, ws.echoStore.Get(botevent.SelfId), ws.emitterOptions...)
 
 				if slices.Contains(botevent.Types, event.EVENT_META) {
 					connSelfId = botevent.SelfId
@@ -247,7 +267,10 @@ func (ws *WServer) Listen(ctx context.Context, eventChan chan<- event.Event) err
 						emitter: emitter,
 					}
 				}
-				eventChan <- botevent
+				select {
+				case eventChan <- botevent:
+				case <-ctx.Done():
+				}
 			}()
 		}
 	})
@@ -262,7 +285,10 @@ func (ws *WServer) Listen(ctx context.Context, eventChan chan<- event.Event) err
 			return
 		}
 	}()
-	return server.ListenAndServe()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func (ws *WServer) auth(r *http.Request) error {
@@ -327,6 +353,25 @@ func (ws *WSEmittersMux) RemoveEmitter(selfId int64) {
 	}
 }
 
+// Close sends a close frame to every connected onebot and closes the connections.
+func (ws *WSEmittersMux) Close() error {
+	ws.mu.RLock()
+	emitters := make([]Emitter, 0, len(ws.emitters))
+	for _, emitter := range ws.emitters {
+		emitters = append(emitters, emitter)
+	}
+	ws.mu.RUnlock()
+	var errs []error
+	for _, emitter := range emitters {
+		if e, ok := emitter.(*EmitterWS); ok {
+			if err := e.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (ws *WSEmittersMux) OnClose(callback func(int64)) {
 	ws.onClose = callback
 }
@@ -342,122 +387,99 @@ func (ws *WSEmittersMux) GetEmitter(selfId int64) (Emitter, error) {
 }
 
 type EmitterWS struct {
-	mu     sync.Mutex
-	conn   *websocket.Conn
-	echo   chan Response[json.RawMessage]
-	selfId int64
-	log    *slog.Logger
+	mu   sync.Mutex
+	conn *websocket.Conn
+	// the first failed write, the connection is broken and later actions are not written
+	writeErr error
+	echo     chan Response[json.RawMessage]
+	selfId   int64
+	timeouts actionTimeouts
+	log      *slog.Logger
+}
+
+type EmitterWSOption func(*EmitterWS)
+
+// Set the time to wait for the echo of every action, default is EchoTimeOut.
+func WithEmitterWSTimeout(timeout time.Duration) EmitterWSOption {
+	return func(e *EmitterWS) {
+		e.timeouts.timeout = timeout
+	}
+}
+
+// Set the time to wait for the echo of action, such as a longer timeout for file uploads.
+func WithEmitterWSActionTimeout(action Action, timeout time.Duration) EmitterWSOption {
+	return func(e *EmitterWS) {
+		e.timeouts.set(action, timeout)
+	}
 }
 
-func NewEmitterWS(selfId int64, conn *websocket.Conn, echo chan Response[json.RawMessage]) *EmitterWS {
-	return &EmitterWS{
+func NewEmitterWS(selfId int64, conn *websocket.Conn, echo chan Response[json.RawMessage], opts ...EmitterWSOption) *EmitterWS {
+	emitter := &EmitterWS{
 		conn:   conn,
 		echo:   echo,
 		selfId: selfId,
-		log:    nlog.Logger(),
+		log:    nlog.Component("driver"),
+	}
+	for _, opt := range opts {
+		opt(emitter)
 	}
+	return emitter
 }
 
-func (e *EmitterWS) SendPvtMsg(ctx context.Context, userId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+func (e *EmitterWS) Close() error {
 	e.mu.Lock()
-	echoId, err := wsAction(e.conn, ACTION_SEND_PRIVATE_MSG, types.SendPrivateMsgReq{
+	defer e.mu.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutdown")
+	if err := e.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		e.log.Warn("Write close message error", "err", err, "selfId", e.selfId)
+	}
+	return e.conn.Close()
+}
+
+func (e *EmitterWS) SendPvtMsg(ctx context.Context, userId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return wsCall[types.SendPrivateMsgReq, types.SendMsgRes](ctx, e, ACTION_SEND_PRIVATE_MSG, types.SendPrivateMsgReq{
 		UserId:  userId,
 		Message: msg,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return nil, err
-	}
-	e.mu.Unlock()
-	return wsWait[types.SendMsgRes](ctx, echoId, e.echo)
 }
 
 func (e *EmitterWS) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
-	e.mu.Lock()
-	echoId, err := wsAction(e.conn, ACTION_SEND_GROUP_MSG, types.SendGrMsgReq{
+	return wsCall[types.SendGrMsgReq, types.SendMsgRes](ctx, e, ACTION_SEND_GROUP_MSG, types.SendGrMsgReq{
 		GroupId: groupId,
 		Message: msg,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return nil, err
-	}
-	e.mu.Unlock()
-	return wsWait[types.SendMsgRes](ctx, echoId, e.echo)
 }
 
 func (e *EmitterWS) DelMsg(ctx context.Context, msgId int) error {
-	e.mu.Lock()
-	echoId, err := wsAction[any](e.conn, ACTION_DELETE_MSG, types.DelMsgReq{
+	_, err := wsCall[types.DelMsgReq, any](ctx, e, ACTION_DELETE_MSG, types.DelMsgReq{
 		MessageId: msgId,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return err
-	}
-	e.mu.Unlock()
-	_, err = wsWait[any](ctx, echoId, e.echo)
 	return err
 }
 
 func (e *EmitterWS) GetMsg(ctx context.Context, msgId int) (*types.GetMsgRes, error) {
-	e.mu.Lock()
-	echoId, err := wsAction(e.conn, ACTION_GET_MSG, types.GetMsgReq{
+	return wsCall[types.GetMsgReq, types.GetMsgRes](ctx, e, ACTION_GET_MSG, types.GetMsgReq{
 		MessageId: msgId,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return nil, err
-	}
-	e.mu.Unlock()
-	return wsWait[types.GetMsgRes](ctx, echoId, e.echo)
 }
 
 func (e *EmitterWS) GetLoginInfo(ctx context.Context) (*types.LoginInfo, error) {
-	e.mu.Lock()
-	echoId, err := wsAction[any](e.conn, ACTION_GET_LOGIN_INFO, nil)
-	if err != nil {
-		e.mu.Unlock()
-		return nil, err
-	}
-	e.mu.Unlock()
-	return wsWait[types.LoginInfo](ctx, echoId, e.echo)
+	return wsCall[any, types.LoginInfo](ctx, e, ACTION_GET_LOGIN_INFO, nil)
 }
 
 func (e *EmitterWS) GetStrangerInfo(ctx context.Context, userId int64, noCache bool) (*types.StrangerInfo, error) {
-	e.mu.Lock()
-	echoId, err := wsAction(e.conn, ACTION_GET_STRANGER_INFO, types.GetStrangerInfo{
+	return wsCall[types.GetStrangerInfo, types.StrangerInfo](ctx, e, ACTION_GET_STRANGER_INFO, types.GetStrangerInfo{
 		UserId:  userId,
 		NoCache: noCache,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return nil, err
-	}
-	e.mu.Unlock()
-	return wsWait[types.StrangerInfo](ctx, echoId, e.echo)
 }
 
 func (e *EmitterWS) GetStatus(ctx context.Context) (*types.Status, error) {
-	e.mu.Lock()
-	echoId, err := wsAction[any](e.conn, ACTION_GET_STATUS, nil)
-	if err != nil {
-		e.mu.Unlock()
-		return nil, err
-	}
-	e.mu.Unlock()
-	return wsWait[types.Status](ctx, echoId, e.echo)
+	return wsCall[any, types.Status](ctx, e, ACTION_GET_STATUS, nil)
 }
 
 func (e *EmitterWS) GetVersionInfo(ctx context.Context) (*types.VersionInfo, error) {
-	e.mu.Lock()
-	echoId, err := wsAction[any](e.conn, ACTION_GET_VERSION_INFO, nil)
-	if err != nil {
-		e.mu.Unlock()
-		return nil, err
-	}
-	e.mu.Unlock()
-	return wsWait[types.VersionInfo](ctx, echoId, e.echo)
+	return wsCall[any, types.VersionInfo](ctx, e, ACTION_GET_VERSION_INFO, nil)
 }
 
 func (e *EmitterWS) GetSelfId(ctx context.Context) (int64, error) {
@@ -465,67 +487,46 @@ func (e *EmitterWS) GetSelfId(ctx context.Context) (int64, error) {
 }
 
 func (e *EmitterWS) SetFriendAddRequest(ctx context.Context, flag string, approve bool, remark string) error {
-	e.mu.Lock()
-	echoId, err := wsAction(e.conn, ACTION_SET_FRIEND_ADD_REQUEST, types.FriendAddReq{
+	_, err := wsCall[types.FriendAddReq, any](ctx, e, ACTION_SET_FRIEND_ADD_REQUEST, types.FriendAddReq{
 		Flag:    flag,
 		Approve: approve,
 		Remark:  remark,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return err
-	}
-	e.mu.Unlock()
-	_, err = wsWait[any](ctx, echoId, e.echo)
 	return err
 }
 
 func (e *EmitterWS) SetGroupAddRequest(ctx context.Context, flag string, approve bool, reason string) error {
-	e.mu.Lock()
-	echoId, err := wsAction(e.conn, ACTION_SET_GROUP_ADD_REQUEST, types.GroupAddReq{
+	_, err := wsCall[types.GroupAddReq, any](ctx, e, ACTION_SET_GROUP_ADD_REQUEST, types.GroupAddReq{
 		Flag:    flag,
 		Approve: approve,
 		Reason:  reason,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return err
-	}
-	e.mu.Unlock()
-	_, err = wsWait[any](ctx, echoId, e.echo)
 	return err
 }
 
 func (e *EmitterWS) SetGroupSpecialTitle(ctx context.Context, groupId int64, userId int64, specialTitle string, duration int) error {
-	e.mu.Lock()
-	echoId, err := wsAction(e.conn, ACTION_SET_GROUP_SPECIAL_TITLE, types.SpecialTitleReq{
+	_, err := wsCall[types.SpecialTitleReq, any](ctx, e, ACTION_SET_GROUP_SPECIAL_TITLE, types.SpecialTitleReq{
 		GroupId:      groupId,
 		UserId:       userId,
 		SpecialTitle: specialTitle,
 	})
-	if err != nil {
-		e.mu.Unlock()
-		return err
-	}
-	e.mu.Unlock()
-	_, err = wsWait[any](ctx, echoId, e.echo)
 	return err
 }
 
-func (e *EmitterWS) Raw(ctx context.Context, action Action, params any) ([]byte, error) {
-	e.mu.Lock()
-	echoId, err := wsAction(e.conn, action, params)
+func (e *EmitterWS) Raw(ctx context.Context, action Action, params any) (body []byte, err error) {
+	start := time.Now()
+	defer func() { observeAction(ctx, action, start, err) }()
+	echoId, err := wsAction(e, action, params)
 	if err != nil {
-		e.mu.Unlock()
 		return nil, err
 	}
-	e.mu.Unlock()
-	ctx, cancel := context.WithTimeout(ctx, EchoTimeOut)
+	ctx, cancel := e.timeouts.context(ctx, action, EchoTimeOut)
 	defer cancel()
+	defer echoWait.Since(time.Now(), action)
 	for {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, &TransportError{Action: action, Err: ctx.Err()}
 		case echo := <-e.echo:
 			if !strings.EqualFold(echoId, echo.Echo) {
 				e.echo <- echo
@@ -536,35 +537,64 @@ func (e *EmitterWS) Raw(ctx context.Context, action Action, params any) ([]byte,
 	}
 }
 
-func wsAction[P any](conn *websocket.Conn, action string, params P) (string, error) {
+// wsCall writes the action and waits for its echo.
+func wsCall[P any, R any](ctx context.Context, e *EmitterWS, action string, params P) (res *R, err error) {
+	start := time.Now()
+	defer func() { observeAction(ctx, action, start, err) }()
+	echoId, err := wsAction(e, action, params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := e.timeouts.context(ctx, action, EchoTimeOut)
+	defer cancel()
+	return wsWait[R](ctx, action, echoId, e.echo)
+}
+
+// wsAction writes the action, only the errors before the write mark it as unsent,
+// a failed write may have reached the implementation.
+func wsAction[P any](e *EmitterWS, action string, params P) (string, error) {
 	echoid := uuid.New().String()
-	return echoid, conn.WriteJSON(Request[P]{
+	data, err := json.Marshal(Request[P]{
 		Action: action,
 		Echo:   echoid,
 		Params: params,
 	})
+	if err != nil {
+		return "", &TransportError{Action: action, Unsent: true, Err: err}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.writeErr != nil {
+		return "", &TransportError{Action: action, Unsent: true, Err: e.writeErr}
+	}
+	if err := e.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		// the close frame was sent before, nothing was written
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return "", &TransportError{Action: action, Unsent: true, Err: err}
+		}
+		e.writeErr = err
+		return "", &TransportError{Action: action, Err: err}
+	}
+	return echoid, nil
 }
 
-func wsWait[R any](ctx context.Context, echoId string, echoChan chan Response[json.RawMessage]) (*R, error) {
-	ctx, cancel := context.WithTimeout(ctx, EchoTimeOut)
-	defer cancel()
+// wsWait waits for the echo until ctx is done.
+func wsWait[R any](ctx context.Context, action string, echoId string, echoChan chan Response[json.RawMessage]) (*R, error) {
+	defer echoWait.Since(time.Now(), action)
 	for {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, &TransportError{Action: action, Err: ctx.Err()}
 		case echo := <-echoChan:
 			if !strings.EqualFold(echoId, echo.Echo) {
 				echoChan <- echo
 				continue
 			}
-			if strings.EqualFold("failed", echo.Status) {
-				return nil, fmt.Errorf("action failed, rawdata: %x, please see onebot logs", echo.Status)
-			}
-			var res R
-			if err := json.Unmarshal(echo.Data, &res); err != nil {
+			body, err := json.Marshal(echo)
+			if err != nil {
 				return nil, err
 			}
-			return &res, nil
+			return decodeResponse[R](action, echo, body)
 		}
 	}
 }
@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialEmitterWS(t *testing.T) *EmitterWS {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return NewEmitterWS(1, conn, make(chan Response[json.RawMessage], 1))
+}
+
+func TestEmitterWSUnsent(t *testing.T) {
+	// a write failing on the connection may have reached the implementation
+	e := dialEmitterWS(t)
+	e.conn.NetConn().Close()
+	_, err := e.Raw(context.Background(), ACTION_SEND_GROUP_MSG, nil)
+	assert.Error(t, err)
+	assert.False(t, Unsent(err))
+
+	// the later actions are not written
+	_, err = e.Raw(context.Background(), ACTION_SEND_GROUP_MSG, nil)
+	assert.True(t, Unsent(err))
+
+	// after the close frame nothing is written
+	e = dialEmitterWS(t)
+	assert.NoError(t, e.Close())
+	_, err = e.Raw(context.Background(), ACTION_SEND_GROUP_MSG, nil)
+	assert.True(t, Unsent(err))
+}
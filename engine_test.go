@@ -0,0 +1,265 @@
+package nsxbot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeListener sends events and waits for the engine to stop.
+type fakeListener struct {
+	events []event.Event
+}
+
+func (f *fakeListener) Listen(ctx context.Context, ch chan<- event.Event) error {
+	for _, e := range f.events {
+		select {
+		case ch <- e:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+type fakeMux struct {
+	driver.Emitter
+}
+
+func (f *fakeMux) GetEmitter(selfId int64) (driver.Emitter, error) {
+	return f.Emitter, nil
+}
+
+func (f *fakeMux) AddEmitter(selfId int64, emitter driver.Emitter) {}
+
+func groupEvent(time int64, groupId int64, userId int64, text string) event.Event {
+	data := fmt.Sprintf(`{"post_type":"message","message_type":"group","time":%d,"self_id":1,"group_id":%d,"user_id":%d,"raw_message":%q,"message":[{"type":"text","data":{"text":%q}}]}`,
+		time, groupId, userId, text, text)
+	e, err := driver.Onebot11ContentToEvent([]byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// start runs e on events until the returned stop is called.
+func start(t *testing.T, e *Engine, events ...event.Event) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}
+}
+
+func TestOrderedByGroup(t *testing.T) {
+	e := New(&fakeListener{events: []event.Event{
+		groupEvent(1, 1, 10, "1"), groupEvent(2, 1, 10, "2"), groupEvent(3, 1, 10, "3"), groupEvent(4, 1, 10, "4"),
+	}}, &fakeMux{})
+	handler := OnEvent[event.GroupMessage](e)
+	handler.SetOrdered(OrderByGroup)
+	var mu sync.Mutex
+	var order []string
+	handler.Handle(func(ctx *Context[event.GroupMessage]) {
+		// the earlier events take longer
+		time.Sleep(time.Duration(5-ctx.Time) * 5 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, ctx.Msg.RawMessage)
+	})
+	stop := start(t, e)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 4
+	}, time.Second, 5*time.Millisecond)
+	stop()
+	assert.Equal(t, []string{"1", "2", "3", "4"}, order)
+}
+
+func TestPriorityBlock(t *testing.T) {
+	e := New(&fakeListener{events: []event.Event{groupEvent(1, 1, 10, "hi")}}, &fakeMux{})
+	handler := OnEvent[event.GroupMessage](e)
+	var first, blocked, unhandled atomic.Int32
+	handler.Priority(1).Handle(func(ctx *Context[event.GroupMessage]) {
+		blocked.Add(1)
+	})
+	handler.Priority(0).Block().Handle(func(ctx *Context[event.GroupMessage]) {
+		first.Add(1)
+	})
+	handler.Unhandled(func(ctx *Context[event.GroupMessage]) {
+		unhandled.Add(1)
+	})
+	stop := start(t, e)
+	assert.Eventually(t, func() bool { return first.Load() == 1 }, time.Second, 5*time.Millisecond)
+	stop()
+	assert.Zero(t, blocked.Load())
+	assert.Zero(t, unhandled.Load())
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	e := New(&fakeListener{events: []event.Event{groupEvent(1, 1, 10, "hi")}}, &fakeMux{})
+	e.SetHandlerTimeout(20 * time.Millisecond)
+	errs := make(chan error, 1)
+	OnEvent[event.GroupMessage](e).Handle(func(ctx *Context[event.GroupMessage]) {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	})
+	stop := start(t, e)
+	defer stop()
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("handler not canceled")
+	}
+}
+
+func TestPanicLimit(t *testing.T) {
+	e := New(&fakeListener{events: []event.Event{
+		groupEvent(1, 1, 10, "1"), groupEvent(2, 1, 10, "2"), groupEvent(3, 1, 10, "3"),
+	}}, &fakeMux{})
+	e.SetPanicLimit(2, time.Minute)
+	var reports atomic.Int32
+	var disabled atomic.Bool
+	e.OnPanic(func(info *PanicInfo) {
+		reports.Add(1)
+		if info.Disabled {
+			disabled.Store(true)
+		}
+	})
+	handler := OnEvent[event.GroupMessage](e)
+	handler.SetOrdered(OrderByGroup)
+	reg := handler.Handle(func(ctx *Context[event.GroupMessage]) {
+		panic("boom")
+	})
+	stop := start(t, e)
+	assert.Eventually(t, func() bool { return reports.Load() == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	// the handler is paused at the second panic and skips the third event
+	assert.Equal(t, int32(2), reports.Load())
+	assert.True(t, disabled.Load())
+	assert.True(t, reg.Paused())
+}
+
+func TestPanicLimitPerHandler(t *testing.T) {
+	e := New(&fakeListener{events: []event.Event{
+		groupEvent(1, 1, 10, "1"), groupEvent(2, 1, 10, "2"), groupEvent(3, 1, 10, "3"),
+	}}, &fakeMux{})
+	e.SetPanicLimit(2, time.Minute)
+	handler := OnEvent[event.GroupMessage](e)
+	handler.SetOrdered(OrderByGroup)
+	plugin := handler.Route("plugin")
+	var healthy atomic.Int32
+	routes := make(chan string, 3)
+	// both closures are named by the plugin, only the panicking one is paused
+	failing := plugin.Handle(func(ctx *Context[event.GroupMessage]) {
+		routes <- ctx.Route()
+		panic("boom")
+	})
+	working := plugin.Handle(func(ctx *Context[event.GroupMessage]) {
+		healthy.Add(1)
+	})
+	stop := start(t, e)
+	assert.Eventually(t, func() bool { return healthy.Load() == 3 }, time.Second, 5*time.Millisecond)
+	stop()
+	assert.True(t, failing.Paused())
+	assert.False(t, working.Paused())
+	assert.Equal(t, "plugin", <-routes)
+}
+
+func TestLongMiddlewareChain(t *testing.T) {
+	e := New(&fakeListener{events: []event.Event{groupEvent(1, 1, 10, "hi")}}, &fakeMux{})
+	handler := OnEvent[event.GroupMessage](e)
+	countKey := NewKey[int]("count")
+	// more middleware than an int8 index holds
+	for range 200 {
+		handler.Use(func(ctx *Context[event.GroupMessage]) {
+			count, _ := countKey.Get(ctx)
+			countKey.Set(ctx, count+1)
+			ctx.Next()
+		})
+	}
+	counts := make(chan int, 1)
+	handler.Handle(func(ctx *Context[event.GroupMessage]) {
+		counts <- countKey.MustGet(ctx)
+	})
+	stop := start(t, e)
+	defer stop()
+	select {
+	case count := <-counts:
+		assert.Equal(t, 200, count)
+	case <-time.After(time.Second):
+		t.Fatal("handler not reached")
+	}
+}
+
+func TestContextBucket(t *testing.T) {
+	e := New(&fakeListener{events: []event.Event{groupEvent(1, 1, 10, "1"), groupEvent(2, 1, 10, "2")}}, &fakeMux{})
+	var handled atomic.Int32
+	handler := OnEvent[event.GroupMessage](e)
+	handler.SetOrdered(OrderByGroup)
+	handler.Handle(func(ctx *Context[event.GroupMessage]) {
+		defer handled.Add(1)
+		counter := storage.Of[int](ctx.Bucket("count"))
+		_, err := counter.Update(ctx, "n", 0, func(n int, ok bool) (int, error) { return n + 1, nil })
+		assert.NoError(t, err)
+	})
+	stop := start(t, e)
+	assert.Eventually(t, func() bool { return handled.Load() == 2 }, time.Second, 5*time.Millisecond)
+	stop()
+	// the events share the storage of the engine
+	n, err := storage.Of[int](e.Storage().Bucket("count")).Get(context.Background(), "n")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	ctx := NewContext(context.Background(), nil, 0, 0, groupMessage(1, 10), nil)
+	assert.Panics(t, func() { ctx.Bucket("count") })
+}
+
+func TestRegistrationDuringRun(t *testing.T) {
+	events := make([]event.Event, 500)
+	for i := range events {
+		events[i] = groupEvent(int64(i), int64(i%7), 10, "hi")
+	}
+	e := New(&fakeListener{events: events}, &fakeMux{})
+	handler := OnEvent[event.GroupMessage](e)
+	var kept atomic.Int32
+	handler.Handle(func(ctx *Context[event.GroupMessage]) {
+		kept.Add(1)
+	})
+	stop := start(t, e)
+
+	// register, pause and remove handlers while the events are dispatched
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				reg := handler.Priority(i).Handle(func(ctx *Context[event.GroupMessage]) {})
+				if j%2 == 0 {
+					reg.Pause()
+					reg.Resume()
+				}
+				reg.Remove()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Eventually(t, func() bool { return kept.Load() == int32(len(events)) }, 5*time.Second, 5*time.Millisecond)
+	stop()
+}
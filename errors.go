@@ -0,0 +1,191 @@
+package nsxbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/tidwall/gjson"
+)
+
+// ErrHandlerFunc is a handler returning an error, the error is added to the Context and passed to the error handlers.
+type ErrHandlerFunc[T any] func(ctx *Context[T]) error
+
+// WithError adapts handler to a HandlerFunc, to use it in Composer.Handle or NewConversation.
+// Composer.HandleE keeps the function name of handler as route.
+func WithError[T any](handler ErrHandlerFunc[T]) HandlerFunc[T] {
+	return func(ctx *Context[T]) {
+		if err := handler(ctx); err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// UserError is an error whose message can be shown to the user.
+type UserError struct {
+	// the message replied to the user
+	Message string
+	Err     error
+}
+
+func NewUserError(message string, err error) *UserError {
+	return &UserError{Message: message, Err: err}
+}
+
+// UserErrorf creates a UserError without cause.
+func UserErrorf(format string, args ...any) *UserError {
+	return &UserError{Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *UserError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *UserError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerError holds the errors of a handler chain for the error handlers.
+type HandlerError struct {
+	// not canceled when the handler times out, so error handlers can still reply
+	context.Context
+	driver.Emitter
+
+	Route   string
+	SelfId  int64
+	GroupId int64
+	UserId  int64
+	TraceId string
+	Errors  []error
+	Log     *slog.Logger
+}
+
+// Reply sends msg to the group of the event, or to the user outside groups.
+func (e *HandlerError) Reply(msg schema.MessageChain) error {
+	var err error
+	switch {
+	case e.GroupId != 0:
+		_, err = e.SendGrMsg(e, e.GroupId, msg)
+	case e.UserId != 0:
+		_, err = e.SendPvtMsg(e, e.UserId, msg)
+	default:
+		err = errors.New("no chat to reply")
+	}
+	return err
+}
+
+// ErrorHandler is called after a handler chain added errors to its Context.
+type ErrorHandler func(herr *HandlerError)
+
+// OnError adds error handlers, without error handlers the errors are logged.
+func (e *Engine) OnError(handlers ...ErrorHandler) {
+	e.errorHandlers = append(e.errorHandlers, handlers...)
+}
+
+func (e *Engine) handleErrors(herr *HandlerError) {
+	if len(e.errorHandlers) == 0 {
+		LogErrors()(herr)
+		return
+	}
+	for _, handler := range e.errorHandlers {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					herr.Log.Error("Error handler panic", "err", err)
+				}
+			}()
+			handler(herr)
+		}()
+	}
+}
+
+// handleErrors passes the errors added to c to the error handlers of the engine.
+func (c *Context[T]) handleErrors() {
+	if len(c.errors) == 0 || c.engine == nil {
+		return
+	}
+	herr := &HandlerError{
+		Context: context.WithoutCancel(c.Context),
+		Emitter: c.Emitter,
+		Route:   c.route,
+		SelfId:  c.SelfId,
+		TraceId: c.TraceId(),
+		Errors:  c.errors,
+		Log:     c.Log,
+	}
+	if data, err := json.Marshal(c.Msg); err == nil {
+		herr.GroupId = gjson.GetBytes(data, "group_id").Int()
+		herr.UserId = gjson.GetBytes(data, "user_id").Int()
+	}
+	c.engine.handleErrors(herr)
+}
+
+// LogErrors logs the errors, user errors at warn level.
+func LogErrors() ErrorHandler {
+	return func(herr *HandlerError) {
+		for _, err := range herr.Errors {
+			var userErr *UserError
+			if errors.As(err, &userErr) {
+				herr.Log.Warn("Handler user error", "err", err)
+				continue
+			}
+			herr.Log.Error("Handler error", "err", err)
+		}
+	}
+}
+
+// ReplyErrors replies the message of user errors, and friendly for other errors if it is not empty.
+func ReplyErrors(friendly string) ErrorHandler {
+	return func(herr *HandlerError) {
+		var replies []string
+		for _, err := range herr.Errors {
+			var userErr *UserError
+			if errors.As(err, &userErr) {
+				replies = append(replies, userErr.Message)
+			}
+		}
+		if len(replies) == 0 && len(friendly) != 0 {
+			replies = append(replies, friendly)
+		}
+		if len(replies) == 0 {
+			return
+		}
+		var msg schema.MessageChain
+		if err := herr.Reply(msg.Text(strings.Join(replies, "\n"))); err != nil {
+			herr.Log.Error("Reply error", "err", err)
+		}
+	}
+}
+
+// ReportErrors sends the errors other than user errors to superUsers by private message.
+func ReportErrors(superUsers ...int64) ErrorHandler {
+	return func(herr *HandlerError) {
+		var lines []string
+		for _, err := range herr.Errors {
+			var userErr *UserError
+			if !errors.As(err, &userErr) {
+				lines = append(lines, err.Error())
+			}
+		}
+		if len(lines) == 0 {
+			return
+		}
+		text := fmt.Sprintf("route: %s\nselfId: %d\ngroup: %d\nuser: %d\ntrace: %s\n%s",
+			herr.Route, herr.SelfId, herr.GroupId, herr.UserId, herr.TraceId, strings.Join(lines, "\n"))
+		var msg schema.MessageChain
+		msg = msg.Text(text)
+		for _, superUser := range superUsers {
+			if _, err := herr.SendPvtMsg(herr, superUser, msg); err != nil {
+				herr.Log.Error("Report error", "superUser", superUser, "err", err)
+			}
+		}
+	}
+}
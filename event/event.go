@@ -12,6 +12,8 @@ type Eventer interface {
 }
 
 type Event struct {
+	// set by the listener to correlate the logs of the event
+	TraceId string
 	Types   []string
 	Time    int64
 	SelfId  int64
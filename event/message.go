@@ -39,6 +39,11 @@ type CommonMessage struct {
 func (cm CommonMessage) Id() int {
 	return cm.MessageId
 }
+
+// session key of the sender across all chats
+func (cm CommonMessage) UserSessionKey() string {
+	return fmt.Sprintf("user:%d", cm.UserId)
+}
 func (cm CommonMessage) Reply(replyer Replyer, text string) error {
 	if replyer == nil {
 		return ErrNoAvailable
@@ -172,6 +177,11 @@ func (gm GroupMessage) SessionKey() string {
 	return fmt.Sprintf("%s:%d", gm.Type(), gm.GroupId)
 }
 
+// session key of the sender in this group
+func (gm GroupMessage) MemberSessionKey() string {
+	return fmt.Sprintf("%s:%d:%d", gm.Type(), gm.GroupId, gm.UserId)
+}
+
 type AllMessage struct {
 	CommonMessage
 	GroupId   int64     `json:"group_id"`
@@ -185,3 +195,8 @@ func (am AllMessage) Type() string {
 func (am AllMessage) SessionKey() string {
 	return fmt.Sprintf("%s:%s:%d", am.Type(), am.SubType, am.GroupId)
 }
+
+// session key of the sender in this chat
+func (am AllMessage) MemberSessionKey() string {
+	return fmt.Sprintf("%s:%s:%d:%d", am.Type(), am.SubType, am.GroupId, am.UserId)
+}
@@ -37,17 +37,40 @@ func main() {
 	})
 
 	ge2 := gr.Compose(filter.OnlyGroups(517170497))
+	// middleware passes the parsed text to the handler
+	ge2.Use(func(ctx *nsxbot.Context[event.GroupMessage]) {
+		text, err := ctx.Msg.TextFirst()
+		if err != nil {
+			ctx.Abort()
+			return
+		}
+		textKey.Set(ctx, text.Text)
+		ctx.Next()
+	})
 	ge2.Handle(ontext)
 
+	// commands run first and stop the chat handler from answering the same message
+	gr.Block().Handle(func(ctx *nsxbot.Context[event.GroupMessage]) {
+		ctx.Msg.Reply(ctx, "pong")
+	}, filter.OnCommand[event.GroupMessage]("/", "ping"))
+	gr.Priority(10).Handle(func(ctx *nsxbot.Context[event.GroupMessage]) {
+		slog.Info("Chat", "message", ctx.Msg.RawMessage)
+	})
+	// a one-off handler removes itself after the first vote
+	gr.Handle(func(ctx *nsxbot.Context[event.GroupMessage]) {
+		slog.Info("Vote", "user", ctx.Msg.UserId, "message", ctx.Msg.RawMessage)
+		ctx.Registration().Remove()
+	}, filter.OnCommand[event.GroupMessage]("/", "vote"))
+	gr.Unhandled(func(ctx *nsxbot.Context[event.GroupMessage]) {
+		slog.Debug("Nobody handled", "message", ctx.Msg.RawMessage)
+	})
+
 	// Run
 	bot.Run(ctx)
 }
 
+var textKey = nsxbot.NewKey[string]("text")
+
 func ontext(ctx *nsxbot.Context[event.GroupMessage]) {
-	text, err := ctx.Msg.TextFirst()
-	if err != nil {
-		slog.Error("Error parsing text message", "error", err)
-		return
-	}
-	slog.Info("Group Message", "message", text.Text)
+	slog.Info("Group Message", "message", textKey.MustGet(ctx))
 }
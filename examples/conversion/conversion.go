@@ -4,6 +4,7 @@ import (
 	"context"
 	"slices"
 	"strings"
+	"time"
 
 	nsx "github.com/nsxdevx/nsxbot"
 	"github.com/nsxdevx/nsxbot/driver"
@@ -18,7 +19,13 @@ func main() {
 
 	pvt := nsx.OnEvent[event.GroupMessage](bot)
 
-	pvt.Handle(nsx.NewConversation(handler))
+	pvt.Handle(nsx.NewConversation(handler,
+		nsx.WithSessionScope[event.GroupMessage](nsx.ScopeUserInGroup),
+		nsx.WithSessionTTL[event.GroupMessage](time.Minute),
+		nsx.OnSessionTimeout(func(ctx *nsx.Context[event.GroupMessage]) {
+			ctx.Msg.Reply(ctx, "设置超时，请重新开始！")
+		}),
+	))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
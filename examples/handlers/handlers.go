@@ -3,16 +3,20 @@ package main
 import (
 	"context"
 	"log/slog"
+	"time"
 
 	"github.com/nsxdevx/nsxbot"
 	"github.com/nsxdevx/nsxbot/driver"
 	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/filter"
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	bot := nsxbot.Default(driver.NewDriverHttp(":8080", "http://localhost:4000"))
+	// handlers and their emitter calls are cancelled after 30 seconds
+	bot.SetHandlerTimeout(30 * time.Second)
 
 	all := nsxbot.OnEvent[event.AllMessage](bot)
 	all.Handle(func(ctx *nsxbot.Context[event.AllMessage]) {
@@ -25,6 +29,7 @@ func main() {
 	})
 
 	pvt := nsxbot.OnEvent[event.PrivateMessage](bot)
+	pvt.Use(nsxbot.Timeout[event.PrivateMessage](5 * time.Second))
 	pvt.Handle(func(ctx *nsxbot.Context[event.PrivateMessage]) {
 		text, err := ctx.Msg.TextFirst()
 		if err != nil {
@@ -33,6 +38,19 @@ func main() {
 		}
 		slog.Info("Private Message", "message", text.Text)
 	})
+	// name the closure in logs, metrics and panic reports
+	pvt.Route("image").HandleE(func(ctx *nsxbot.Context[event.PrivateMessage]) error {
+		if _, err := ctx.Msg.ImageFirst(); err != nil {
+			return nsxbot.NewUserError("please send an image", err)
+		}
+		return nil
+	}, filter.OnCommand[event.PrivateMessage]("/", "image"))
+
+	// log all errors, reply user errors and report the others to the admin
+	// report panics and pause a handler panicking 3 times within a minute
+	bot.OnPanic(nsxbot.ReportPanics(123456789), nsxbot.CrashDumps("crash"))
+	bot.SetPanicLimit(3, time.Minute)
+	bot.OnError(nsxbot.LogErrors(), nsxbot.ReplyErrors("something went wrong"), nsxbot.ReportErrors(123456789))
 
 	// Run
 	bot.Run(ctx)
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"os"
+	"regexp"
 	"strconv"
 
 	"github.com/nsxdevx/nsxbot"
@@ -22,8 +23,20 @@ func main() {
 	groupId, _ := strconv.ParseInt(os.Getenv("TEST_GROUP"), 10, 64)
 
 	bot := nsxbot.Default(driver.NewWSverver(":8081", "/"))
+	// drop notices first when the bots are flooded
+	bot.SetOverflow(nsxbot.OverflowDropByType, "notice")
+	// one message per second for every bot and one every 3 seconds for every group
+	bot.SetThrottle(driver.WithThrottleRate(1, 5), driver.WithThrottleTargetRate(1.0/3, 2))
+	// every message sent by the bots is logged and stripped of tokens
+	bot.Intercept(nsxbot.LogSends(), nsxbot.RedactSecrets(regexp.MustCompile(`sk-[A-Za-z0-9]{16,}`)), nsxbot.MaskWords("傻瓜"))
+	// long messages of the first bot are sent as merged-forward messages, split for the others
+	bot.Intercept(nsxbot.LongMessages(nsxbot.WithLongMaxLen(2000), nsxbot.WithLongBotPolicy(aili0uin, nsxbot.LongForward)))
 
 	pvt := nsxbot.OnSelfsEvent[event.GroupMessage](bot, aili0uin, aili1uin)
+	// at most 4 handlers at once, drop the oldest waiting messages during spam bursts
+	pvt.SetPool(nsxbot.NewPool("group", nsxbot.PoolConfig{Workers: 4, QueueSize: 64, Overflow: nsxbot.OverflowDropOldest}))
+	// messages of the same group are handled one after another
+	pvt.SetOrdered(nsxbot.OrderByGroup)
 
 	pvt.Handle(func(ctx *nsxbot.Context[event.GroupMessage]) {
 		info, err := ctx.GetLoginInfo(ctx)
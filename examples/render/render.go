@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/nsxdevx/nsxbot"
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/filter"
+	"github.com/nsxdevx/nsxbot/render"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a font with the glyphs of the messages, such as NotoSansCJK-Regular.ttc for Chinese
+	font, err := os.ReadFile(os.Getenv("CJK_FONT"))
+	if err != nil {
+		slog.Error("Error reading font", "error", err)
+		return
+	}
+	renderer, err := render.New(font)
+	if err != nil {
+		slog.Error("Error loading font", "error", err)
+		return
+	}
+
+	bot := nsxbot.Default(driver.NewDriverHttp(":8080", "http://localhost:4000"))
+	// long messages are sent as images
+	bot.Intercept(nsxbot.LongMessages(nsxbot.WithLongPolicy(nsxbot.LongImage), nsxbot.WithLongRenderer(renderer.RenderMessage)))
+
+	group := nsxbot.OnEvent[event.GroupMessage](bot)
+	group.HandleE(func(ctx *nsxbot.Context[event.GroupMessage]) error {
+		img := renderer.BarChart("Leaderboard", []render.Bar{{Label: "alice", Value: 1203}, {Label: "bob", Value: 980}, {Label: "carol", Value: 45}})
+		msg, err := render.Message(img)
+		if err != nil {
+			return err
+		}
+		_, err = ctx.SendGrMsg(ctx, ctx.Msg.GroupId, msg)
+		return err
+	}, filter.OnCommand[event.GroupMessage]("/", "rank"))
+
+	// Run
+	bot.Run(ctx)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nsxdevx/nsxbot"
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/schema"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	selfId, _ := strconv.ParseInt(os.Getenv("AILI_UIN_0"), 10, 64)
+	groupId, _ := strconv.ParseInt(os.Getenv("TEST_GROUP"), 10, 64)
+
+	bot := nsxbot.Default(driver.NewWSverver(":8081", "/"))
+
+	// daily report at 9:00 Beijing time, queued until the bot is back online
+	if _, err := bot.Cron("CRON_TZ=Asia/Shanghai 0 9 * * *", func(ctx *nsxbot.JobContext) {
+		var msg schema.MessageChain
+		if _, err := ctx.SendGrMsg(ctx, groupId, msg.Text("早上好，今天是"+ctx.Time.Format("2006-01-02"))); err != nil {
+			ctx.Log.Error("Send daily report error", "error", err)
+		}
+	}, nsxbot.WithJobSelfIds(selfId), nsxbot.WithJobOffline(nsxbot.OfflineQueue, 30*time.Second)); err != nil {
+		panic(err)
+	}
+
+	bot.Every(time.Hour, func(ctx *nsxbot.JobContext) {
+		ctx.Log.Info("Hourly heartbeat")
+	})
+
+	// Run
+	bot.Run(ctx)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	nsx "github.com/nsxdevx/nsxbot"
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/filter"
+)
+
+func main() {
+	driver := driver.NewDriverHttp(":8080", "http://localhost:4000")
+
+	bot := nsx.Default(driver)
+
+	store, err := nsx.NewBoltStateStore("sessions.db")
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
+
+	pvt := nsx.OnEvent[event.PrivateMessage](bot)
+	pvt.Handle(nsx.NewConversation(register,
+		nsx.WithStateStore[event.PrivateMessage](store),
+		nsx.WithSessionTTL[event.PrivateMessage](10*time.Minute),
+	))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Run
+	bot.Run(ctx)
+}
+
+const (
+	stepStart = iota
+	stepName
+	stepAge
+)
+
+// register survives restarts, a resumed session continues at the saved step
+func register(ctx *nsx.Context[event.PrivateMessage], sation *nsx.Sation[event.PrivateMessage]) {
+	next := ctx
+	for {
+		switch sation.Step() {
+		case stepStart:
+			if !filter.OnCommand[event.PrivateMessage]("/", "register")(next.Msg) {
+				return
+			}
+			next.Msg.Reply(next, "请输入名字：")
+			sation.Goto(stepName)
+		case stepName:
+			text, err := next.Msg.TextFirst()
+			if err != nil {
+				return
+			}
+			sation.Store("name", text.Text)
+			next.Msg.Reply(next, "请输入年龄：")
+			sation.Goto(stepAge)
+		case stepAge:
+			text, err := next.Msg.TextFirst()
+			if err != nil {
+				return
+			}
+			var name string
+			sation.Load("name", &name)
+			next.Msg.Reply(next, name+" "+text.Text+" 注册成功")
+			return
+		}
+		var err error
+		if next, err = sation.Await(ctx); err != nil {
+			return
+		}
+	}
+}
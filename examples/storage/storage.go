@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/nsxdevx/nsxbot"
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/nsxdevx/nsxbot/filter"
+	"github.com/nsxdevx/nsxbot/storage"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bot := nsxbot.Default(driver.NewDriverHttp(":8080", "http://localhost:4000"))
+
+	db, err := storage.NewBolt("nsxbot.db")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	bot.SetStorage(db)
+
+	gr := nsxbot.OnEvent[event.GroupMessage](bot)
+	gr.Handle(func(ctx *nsxbot.Context[event.GroupMessage]) {
+		counter := storage.Of[int](ctx.Bucket("sign"))
+		key := strconv.FormatInt(ctx.Msg.GroupId, 10) + ":" + strconv.FormatInt(ctx.Msg.UserId, 10)
+		times, err := counter.Update(ctx, key, 0, func(old int, ok bool) (int, error) {
+			return old + 1, nil
+		})
+		if err != nil {
+			ctx.Log.Error("Update sign error", "error", err)
+			return
+		}
+		ctx.Msg.Reply(ctx, "签到成功，累计签到"+strconv.Itoa(times)+"天")
+	}, filter.OnCommand[event.GroupMessage]("/", "sign"))
+
+	// Run
+	bot.Run(ctx)
+}
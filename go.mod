@@ -8,6 +8,7 @@ require (
 	github.com/lmittmann/tint v1.1.1
 	github.com/stretchr/testify v1.10.0
 	github.com/tidwall/gjson v1.18.0
+	go.etcd.io/bbolt v1.4.0
 	golang.org/x/image v0.27.0
 )
 
@@ -16,6 +17,8 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.25.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
@@ -0,0 +1,112 @@
+package nsxbot
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/nlog"
+	"github.com/nsxdevx/nsxbot/types"
+)
+
+// Intercept adds interceptors for the messages sent by handlers, conversations and jobs, the first one is the outermost.
+// Quick replies such as Msg.Reply and the send actions of driver.Call are intercepted too, see driver.Intercepted.
+// They run before the send queue of SetThrottle.
+func (e *Engine) Intercept(interceptors ...driver.Interceptor) {
+	e.interceptors = append(e.interceptors, interceptors...)
+}
+
+func (e *Engine) emitter(selfId int64) (driver.Emitter, error) {
+	emitter, err := e.emitterMux.GetEmitter(selfId)
+	if err != nil || len(e.interceptors) == 0 {
+		return emitter, err
+	}
+	return driver.NewIntercepted(emitter, selfId, e.interceptors...), nil
+}
+
+// LogSends logs every sent message at debug level.
+func LogSends() driver.Interceptor {
+	log := nlog.Component("driver")
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		res, err := next(ctx, out)
+		log := log.With("trace", nlog.TraceId(ctx), "action", out.Action, "selfId", out.SelfId, "groupId", out.GroupId, "userId", out.UserId)
+		if err != nil {
+			log.Warn("Send failed", "message", out.Message, "err", err)
+			return res, err
+		}
+		log.Debug("Sent", "message", out.Message)
+		return res, nil
+	}
+}
+
+// RedactSecrets replaces the matches of patterns in the text of messages with ***, such as tokens or passwords.
+func RedactSecrets(patterns ...*regexp.Regexp) driver.Interceptor {
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		out.Message = out.Message.MapText(func(text string) string {
+			for _, pattern := range patterns {
+				text = pattern.ReplaceAllString(text, "***")
+			}
+			return text
+		})
+		return next(ctx, out)
+	}
+}
+
+// MaskWords replaces the sensitive words in the text of messages with *, one for every character.
+func MaskWords(words ...string) driver.Interceptor {
+	pairs := make([]string, 0, 2*len(words))
+	for _, word := range words {
+		if len(word) != 0 {
+			pairs = append(pairs, word, strings.Repeat("*", len([]rune(word))))
+		}
+	}
+	replacer := strings.NewReplacer(pairs...)
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		out.Message = out.Message.MapText(replacer.Replace)
+		return next(ctx, out)
+	}
+}
+
+// BlockWords vetoes the messages whose text contains a sensitive word.
+func BlockWords(words ...string) driver.Interceptor {
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		text := out.Message.PlainText()
+		for _, word := range words {
+			if len(word) != 0 && strings.Contains(text, word) {
+				return nil, driver.Veto("sensitive word")
+			}
+		}
+		return next(ctx, out)
+	}
+}
+
+// Signature appends signature to every message.
+func Signature(signature string) driver.Interceptor {
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		out.Message = slices.Clip(out.Message).Text(signature)
+		return next(ctx, out)
+	}
+}
+
+// Maintenance vetoes all messages while active returns true, except private messages to allowUsers.
+func Maintenance(active func() bool, allowUsers ...int64) driver.Interceptor {
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		private := out.Action == driver.ACTION_SEND_PRIVATE_MSG || out.Action == driver.ACTION_SEND_PRIVATE_FORWARD_MSG
+		if active() && (!private || !slices.Contains(allowUsers, out.UserId)) {
+			return nil, driver.Veto("maintenance")
+		}
+		return next(ctx, out)
+	}
+}
+
+// Blacklist vetoes the messages to the groups and users blocked returns true for, the group id is 0 for private messages.
+func Blacklist(blocked func(groupId int64, userId int64) bool) driver.Interceptor {
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		if blocked(out.GroupId, out.UserId) {
+			return nil, driver.Veto("blacklisted")
+		}
+		return next(ctx, out)
+	}
+}
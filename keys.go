@@ -0,0 +1,54 @@
+package nsxbot
+
+import "fmt"
+
+// Values is implemented by every Context.
+type Values interface {
+	Set(key string, value any)
+	Get(key string) (any, bool)
+}
+
+// Key is a typed key of the values of a Context, declare it once and share it between middleware and handlers:
+//
+//	var profileKey = nsxbot.NewKey[*Profile]("profile")
+//	profileKey.Set(ctx, profile)
+//	profile, ok := profileKey.Get(ctx)
+type Key[V any] struct {
+	name string
+}
+
+func NewKey[V any](name string) Key[V] {
+	return Key[V]{name: name}
+}
+
+func (k Key[V]) Name() string {
+	return k.name
+}
+
+func (k Key[V]) Set(ctx Values, value V) {
+	ctx.Set(k.name, value)
+}
+
+// Get returns false if the key does not exist or holds a value of another type.
+func (k Key[V]) Get(ctx Values) (V, bool) {
+	value, ok := ctx.Get(k.name)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	v, ok := value.(V)
+	return v, ok
+}
+
+// MustGet panics if the key does not exist or holds a value of another type.
+func (k Key[V]) MustGet(ctx Values) V {
+	value, ok := ctx.Get(k.name)
+	if !ok {
+		panic(fmt.Sprintf("nsxbot: key %q does not exist", k.name))
+	}
+	v, ok := value.(V)
+	if !ok {
+		panic(fmt.Sprintf("nsxbot: key %q holds %T, not %T", k.name, value, v))
+	}
+	return v
+}
@@ -0,0 +1,49 @@
+package nsxbot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextValues(t *testing.T) {
+	ctx := NewContext[event.GroupMessage](context.Background(), nil, 1, 1, event.GroupMessage{}, nil)
+	_, ok := ctx.Get("missing")
+	assert.False(t, ok)
+	assert.PanicsWithValue(t, `nsxbot: key "missing" does not exist`, func() { ctx.MustGet("missing") })
+
+	ctx.Set("name", "nsx")
+	value, ok := ctx.Get("name")
+	assert.True(t, ok)
+	assert.Equal(t, "nsx", value)
+	assert.Equal(t, "nsx", ctx.MustGet("name"))
+}
+
+func TestKey(t *testing.T) {
+	ctx := NewContext[event.GroupMessage](context.Background(), nil, 1, 1, event.GroupMessage{}, nil)
+	countKey := NewKey[int]("count")
+	assert.Equal(t, "count", countKey.Name())
+
+	_, ok := countKey.Get(&ctx)
+	assert.False(t, ok)
+	assert.PanicsWithValue(t, `nsxbot: key "count" does not exist`, func() { countKey.MustGet(&ctx) })
+
+	countKey.Set(&ctx, 3)
+	count, ok := countKey.Get(&ctx)
+	assert.True(t, ok)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 3, countKey.MustGet(&ctx))
+}
+
+func TestKeyTypeMismatch(t *testing.T) {
+	ctx := NewContext[event.GroupMessage](context.Background(), nil, 1, 1, event.GroupMessage{}, nil)
+	ctx.Set("count", "3")
+	countKey := NewKey[int]("count")
+
+	count, ok := countKey.Get(&ctx)
+	assert.False(t, ok)
+	assert.Zero(t, count)
+	assert.PanicsWithValue(t, `nsxbot: key "count" holds string, not int`, func() { countKey.MustGet(&ctx) })
+}
@@ -0,0 +1,138 @@
+package nsxbot
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+)
+
+// LongPolicy decides how LongMessages sends a message with too much text.
+type LongPolicy int
+
+const (
+	// several messages sent one after another, split at segment and line boundaries
+	LongSplit LongPolicy = iota
+	// a merged-forward message with the parts of LongSplit as nodes
+	LongForward
+	// the message rendered by the renderer of WithLongRenderer, LongSplit without renderer
+	LongImage
+)
+
+// Renderer turns a message into a shorter one, such as an image of its text.
+type Renderer func(ctx context.Context, msg schema.MessageChain) (schema.MessageChain, error)
+
+type LongOption func(*longMessages)
+
+// Send messages with more than maxLen characters of text with the policy, default is 3000.
+func WithLongMaxLen(maxLen int) LongOption {
+	return func(l *longMessages) {
+		l.maxLen = maxLen
+	}
+}
+
+// Use policy for the bots without their own policy, default is LongSplit.
+func WithLongPolicy(policy LongPolicy) LongOption {
+	return func(l *longMessages) {
+		l.policy = policy
+	}
+}
+
+// Use policy for the bot selfId.
+func WithLongBotPolicy(selfId int64, policy LongPolicy) LongOption {
+	return func(l *longMessages) {
+		l.bots[selfId] = policy
+	}
+}
+
+// Render messages with renderer for LongImage.
+func WithLongRenderer(renderer Renderer) LongOption {
+	return func(l *longMessages) {
+		l.renderer = renderer
+	}
+}
+
+// Name the nodes of merged-forward messages with nickname, default is the self id of the bot.
+func WithLongForwardName(nickname string) LongOption {
+	return func(l *longMessages) {
+		l.nickname = nickname
+	}
+}
+
+type longMessages struct {
+	maxLen   int
+	policy   LongPolicy
+	bots     map[int64]LongPolicy
+	renderer Renderer
+	nickname string
+}
+
+// LongMessages is an interceptor sending the messages with too much text by the policy of their bot,
+// the backends reject or truncate them otherwise. Add it after the interceptors rewriting the text, see Engine.Intercept.
+func LongMessages(opts ...LongOption) driver.Interceptor {
+	l := &longMessages{
+		maxLen: 3000,
+		bots:   make(map[int64]LongPolicy),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return func(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+		// merged-forward messages are sent as they are
+		if (out.Action != driver.ACTION_SEND_GROUP_MSG && out.Action != driver.ACTION_SEND_PRIVATE_MSG) || out.Message.TextLen() <= l.maxLen {
+			return next(ctx, out)
+		}
+		policy, ok := l.bots[out.SelfId]
+		if !ok {
+			policy = l.policy
+		}
+		switch {
+		case policy == LongImage && l.renderer != nil:
+			rendered, err := l.renderer(ctx, out.Message)
+			if err != nil {
+				return nil, err
+			}
+			out.Message = rendered
+			return next(ctx, out)
+		case policy == LongForward:
+			return l.forward(ctx, out, next)
+		}
+		return l.split(ctx, out, next)
+	}
+}
+
+// split sends the parts one after another, a part is sent after the previous one was sent.
+func (l *longMessages) split(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+	var res *types.SendMsgRes
+	for _, part := range out.Message.Split(l.maxLen) {
+		sent := *out
+		sent.Message = part
+		var err error
+		if res, err = next(ctx, &sent); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// forward sends the parts as nodes of a merged-forward message through the next interceptors.
+func (l *longMessages) forward(ctx context.Context, out *driver.Outgoing, next driver.SendFunc) (*types.SendMsgRes, error) {
+	userId := strconv.FormatInt(out.SelfId, 10)
+	nickname := l.nickname
+	if len(nickname) == 0 {
+		nickname = userId
+	}
+	var nodes schema.MessageChain
+	for _, part := range out.Message.Split(l.maxLen) {
+		nodes = nodes.Node(userId, nickname, part)
+	}
+	sent := *out
+	sent.Action = driver.ACTION_SEND_GROUP_FORWARD_MSG
+	if out.Action == driver.ACTION_SEND_PRIVATE_MSG {
+		sent.Action = driver.ACTION_SEND_PRIVATE_FORWARD_MSG
+	}
+	sent.Message = nodes
+	return next(ctx, &sent)
+}
@@ -0,0 +1,120 @@
+package nsxbot
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/nsxdevx/nsxbot/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type rawEmitter struct {
+	driver.Emitter
+	actions []string
+	params  [][]byte
+}
+
+func (e *rawEmitter) Raw(ctx context.Context, action driver.Action, params any) ([]byte, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	e.actions = append(e.actions, action)
+	e.params = append(e.params, data)
+	return []byte(`{"status":"ok","retcode":0,"data":{"message_id":1}}`), nil
+}
+
+func (e *rawEmitter) SendGrMsg(ctx context.Context, groupId int64, msg schema.MessageChain) (*types.SendMsgRes, error) {
+	return driver.Call[types.SendGrMsgReq, types.SendMsgRes](ctx, e, driver.ACTION_SEND_GROUP_MSG, types.SendGrMsgReq{GroupId: groupId, Message: msg})
+}
+
+// quickReplyer records the quick operations, it can answer once like the http replyer.
+type quickReplyer struct {
+	operations []map[string]any
+}
+
+func (r *quickReplyer) Reply(data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var operation map[string]any
+	if err := json.Unmarshal(body, &operation); err != nil {
+		return err
+	}
+	r.operations = append(r.operations, operation)
+	return nil
+}
+
+func TestLongForward(t *testing.T) {
+	emitter := &rawEmitter{}
+	intercepted := driver.NewIntercepted(emitter, 1,
+		LongMessages(WithLongMaxLen(10), WithLongPolicy(LongForward)), MaskWords("secret"), BlockWords("forbidden"))
+	_, err := intercepted.SendGrMsg(context.Background(), 2, schema.MessageChain{}.Text("hello\nthe secret\nworld"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{driver.ACTION_SEND_GROUP_FORWARD_MSG}, emitter.actions)
+
+	// the nodes pass the interceptors after LongMessages
+	var params struct {
+		GroupId  int64               `json:"group_id"`
+		Messages schema.MessageChain `json:"messages"`
+	}
+	assert.NoError(t, json.Unmarshal(emitter.params[0], &params))
+	assert.Equal(t, int64(2), params.GroupId)
+	assert.Len(t, params.Messages, 3)
+	assert.Equal(t, "hello\nthe ******\nworld", params.Messages.PlainText())
+
+	_, err = intercepted.SendGrMsg(context.Background(), 2, schema.MessageChain{}.Text("a forbidden\nlong message"))
+	assert.ErrorIs(t, err, driver.ErrVetoed)
+	assert.Len(t, emitter.actions, 1)
+}
+
+func TestLongQuickReply(t *testing.T) {
+	content := []byte(`{"post_type":"message","message_type":"group","group_id":2,"user_id":3}`)
+	text := "hello\nlong\nworld"
+	render := func(ctx context.Context, msg schema.MessageChain) (schema.MessageChain, error) {
+		return schema.MessageChain{}.Text("image"), nil
+	}
+	tests := []struct {
+		name      string
+		policy    LongPolicy
+		operation map[string]any
+		// the replies of the quick operation and the actions of the emitter
+		replies []map[string]any
+		actions []string
+	}{
+		{
+			name:      "split",
+			policy:    LongSplit,
+			operation: map[string]any{"reply": text, "at_sender": true},
+			replies:   []map[string]any{{"reply": []any{map[string]any{"type": "text", "data": map[string]any{"text": "hello\n"}}}, "at_sender": true}},
+			actions:   []string{driver.ACTION_SEND_GROUP_MSG, driver.ACTION_SEND_GROUP_MSG},
+		},
+		{
+			name:      "forward",
+			policy:    LongForward,
+			operation: map[string]any{"reply": text, "delete": true},
+			replies:   []map[string]any{{"delete": true}},
+			actions:   []string{driver.ACTION_SEND_GROUP_FORWARD_MSG},
+		},
+		{
+			name:      "image",
+			policy:    LongImage,
+			operation: map[string]any{"reply": text},
+			replies:   []map[string]any{{"reply": []any{map[string]any{"type": "text", "data": map[string]any{"text": "image"}}}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			emitter := &rawEmitter{}
+			intercepted := driver.NewIntercepted(emitter, 1, LongMessages(WithLongMaxLen(6), WithLongPolicy(test.policy), WithLongRenderer(render)))
+			replyer := &quickReplyer{}
+			assert.NoError(t, intercepted.Replyer(context.Background(), replyer, content).Reply(test.operation))
+			assert.Equal(t, test.replies, replyer.operations)
+			assert.Equal(t, test.actions, emitter.actions)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package nsxbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/metrics"
+)
+
+var (
+	eventsReceived = metrics.Default.Counter("nsxbot_events_received_total",
+		"Events received from the listener by event type and self id.", "type", "self_id")
+	handlerDuration = metrics.Default.Histogram("nsxbot_handler_duration_seconds",
+		"Handler chain execution time by route.", nil, "route")
+	handlerPanics = metrics.Default.Counter("nsxbot_handler_panics_total",
+		"Recovered handler panics by route.", "route")
+	handlerTimeouts = metrics.Default.Counter("nsxbot_handler_timeouts_total",
+		"Handlers reaching their deadline by route.", "route")
+	filterRejections = metrics.Default.Counter("nsxbot_filter_rejections_total",
+		"Events rejected by the filters of a route.", "route")
+)
+
+// SetMetricsAddr serves the Prometheus metrics on addr at /metrics while the engine runs, empty addr disables it.
+func (e *Engine) SetMetricsAddr(addr string) {
+	e.metricsAddr = addr
+}
+
+func (e *Engine) serveMetrics(ctx context.Context) {
+	if len(e.metricsAddr) == 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	server := &http.Server{Addr: e.metricsAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			e.log.Error("Metrics server shutdown error", "err", err)
+		}
+	}()
+	go func() {
+		e.log.Info("Metrics server start... ", "addr", e.metricsAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.log.Error("Metrics server error", "err", err)
+		}
+	}()
+}
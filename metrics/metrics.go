@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry used by nsxbot and its drivers.
+var Default = NewRegistry()
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	names   []string
+	metrics map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]collector),
+	}
+}
+
+// register returns the metric of name if it exists, so packages may declare the same metric.
+func register[M collector](r *Registry, name string, metric M) M {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if exist, ok := r.metrics[name]; ok {
+		if m, ok := exist.(M); ok {
+			return m
+		}
+		panic("metrics: " + name + " registered with another type")
+	}
+	r.names = append(r.names, name)
+	r.metrics[name] = metric
+	return metric
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return register(r, name, &CounterVec{vec: newVec[atomicFloat](name, help, "counter", labels)})
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return register(r, name, &GaugeVec{vec: newVec[atomicFloat](name, help, "gauge", labels)})
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on every scrape, registering again replaces fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	register(r, name, &gaugeFunc{name: name, help: help}).fn.Store(&fn)
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return register(r, name, &HistogramVec{vec: newVec[histogram](name, help, "histogram", labels), buckets: slices.Sorted(slices.Values(buckets))})
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := slices.Sorted(slices.Values(r.names))
+	metrics := make([]collector, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, metric := range metrics {
+		metric.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type vec[V any] struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	mu     sync.RWMutex
+	keys   []string
+	values map[string]*V
+}
+
+func newVec[V any](name, help, typ string, labels []string) vec[V] {
+	return vec[V]{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: make(map[string]*V),
+	}
+}
+
+// with returns the value for the label values, missing values are empty.
+func (v *vec[V]) with(init func() *V, labelValues []string) *V {
+	key := strings.Join(labelValues, "\xff")
+	v.mu.RLock()
+	value, ok := v.values[key]
+	v.mu.RUnlock()
+	if ok {
+		return value
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if value, ok := v.values[key]; ok {
+		return value
+	}
+	value = init()
+	v.keys = append(v.keys, key)
+	v.values[key] = value
+	return value
+}
+
+func (v *vec[V]) each(fn func(labels string, value *V)) {
+	v.mu.RLock()
+	keys := slices.Sorted(slices.Values(v.keys))
+	values := make([]*V, len(keys))
+	for i, key := range keys {
+		values[i] = v.values[key]
+	}
+	v.mu.RUnlock()
+	for i, key := range keys {
+		fn(v.formatLabels(strings.Split(key, "\xff")), values[i])
+	}
+}
+
+func (v *vec[V]) header(w *bufio.Writer) {
+	w.WriteString("# HELP " + v.name + " " + strings.ReplaceAll(v.help, "\n", " ") + "\n")
+	w.WriteString("# TYPE " + v.name + " " + v.typ + "\n")
+}
+
+func (v *vec[V]) formatLabels(values []string) string {
+	if len(v.labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, label := range v.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(label + `="` + escape(value) + `"`)
+	}
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	counter := r.Counter("test_total", "Test counter.", "action", "result")
+	counter.Inc("send", "ok")
+	counter.Add(2, "send", "ok")
+	counter.Inc(`say "hi"`, "error")
+	assert.Same(t, counter, r.Counter("test_total", "Test counter.", "action", "result"))
+
+	r.Histogram("test_seconds", "Test histogram.", []float64{1, 0.1}).Observe(0.5)
+	r.GaugeFunc("test_queue", "Test gauge.", func() float64 { return 3 })
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP test_queue Test gauge.
+# TYPE test_queue gauge
+test_queue 3
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 0
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="+Inf"} 1
+test_seconds_sum 0.5
+test_seconds_count 1
+# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{action="say \"hi\"",result="error"} 1
+test_total{action="send",result="ok"} 3
+`, b.String())
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (a *atomicFloat) Add(v float64) {
+	for {
+		old := a.bits.Load()
+		if a.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (a *atomicFloat) Set(v float64) {
+	a.bits.Store(math.Float64bits(v))
+}
+
+func (a *atomicFloat) Load() float64 {
+	return math.Float64frombits(a.bits.Load())
+}
+
+type CounterVec struct {
+	vec[atomicFloat]
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, negative values are ignored.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.with(func() *atomicFloat { return &atomicFloat{} }, labelValues).Add(v)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w)
+	c.each(func(labels string, value *atomicFloat) {
+		w.WriteString(c.name + braces(labels) + " " + formatFloat(value.Load()) + "\n")
+	})
+}
+
+type GaugeVec struct {
+	vec[atomicFloat]
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.with(func() *atomicFloat { return &atomicFloat{} }, labelValues).Set(v)
+}
+
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.with(func() *atomicFloat { return &atomicFloat{} }, labelValues).Add(v)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.header(w)
+	g.each(func(labels string, value *atomicFloat) {
+		w.WriteString(g.name + braces(labels) + " " + formatFloat(value.Load()) + "\n")
+	})
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   atomic.Pointer[func() float64]
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	w.WriteString("# HELP " + g.name + " " + g.help + "\n")
+	w.WriteString("# TYPE " + g.name + " gauge\n")
+	w.WriteString(g.name + " " + formatFloat((*g.fn.Load())()) + "\n")
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	hist := h.with(func() *histogram {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}, labelValues)
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// Since observes the seconds elapsed since start.
+func (h *HistogramVec) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w)
+	h.each(func(labels string, hist *histogram) {
+		hist.mu.Lock()
+		counts := append([]uint64(nil), hist.counts...)
+		count, sum := hist.count, hist.sum
+		hist.mu.Unlock()
+		for i, upper := range h.buckets {
+			le := `le="` + formatFloat(upper) + `"`
+			w.WriteString(h.name + "_bucket{" + joinLabels(labels, le) + "} " + formatFloat(float64(counts[i])) + "\n")
+		}
+		w.WriteString(h.name + "_bucket{" + joinLabels(labels, `le="+Inf"`) + "} " + formatFloat(float64(count)) + "\n")
+		w.WriteString(h.name + "_sum" + braces(labels) + " " + formatFloat(sum) + "\n")
+		w.WriteString(h.name + "_count" + braces(labels) + " " + formatFloat(float64(count)) + "\n")
+	})
+}
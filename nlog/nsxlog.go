@@ -1,40 +1,211 @@
 package nlog
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lmittmann/tint"
 )
 
-var logger *slog.Logger
-var Leveler slog.Leveler
+type Format string
+
+const (
+	// colored text for terminals
+	FormatText   Format = "text"
+	FormatJSON   Format = "json"
+	FormatLogfmt Format = "logfmt"
+)
+
+type Options struct {
+	// default is FormatText, or the env NSX_LOG_FORMAT
+	Format Format
+	// default is os.Stderr, see NewRotateWriter for file output
+	Writer io.Writer
+	// default is time.RFC3339, set time.Kitchen for the short clock of the former text output
+	TimeFormat string
+	AddSource  bool
+}
+
+var level = new(slog.LevelVar)
+
+// Leveler is the global level, assigning another Leveler makes all loggers follow it until the next SetLevel.
+//
+// Deprecated: use SetLevel and Level.
+var Leveler slog.Leveler = level
+
+var (
+	mu         sync.Mutex
+	options    Options
+	base       atomic.Pointer[generation]
+	components sync.Map // name -> *slog.LevelVar
+	logger     = slog.New(&handler{})
+)
+
+type generation struct {
+	handler slog.Handler
+}
+
+func init() {
+	if os.Getenv("NSX_MODE") != "release" {
+		level.Set(slog.LevelDebug)
+	}
+	Setup(Options{Format: Format(os.Getenv("NSX_LOG_FORMAT"))})
+}
+
+// Setup replaces the output of all loggers returned by this package, including loggers created before.
+func Setup(opts Options) {
+	mu.Lock()
+	defer mu.Unlock()
+	options = opts
+	if opts.Writer == nil {
+		opts.Writer = os.Stderr
+	}
+	// levels are checked by handler, the base handler accepts everything
+	minLevel := slog.Level(-1 << 10)
+	var h slog.Handler
+	switch opts.Format {
+	case FormatJSON, FormatLogfmt:
+		if len(opts.TimeFormat) == 0 {
+			opts.TimeFormat = time.RFC3339
+		}
+		handlerOpts := &slog.HandlerOptions{
+			AddSource:   opts.AddSource,
+			Level:       minLevel,
+			ReplaceAttr: formatTime(opts.TimeFormat),
+		}
+		if opts.Format == FormatJSON {
+			h = slog.NewJSONHandler(opts.Writer, handlerOpts)
+		} else {
+			h = slog.NewTextHandler(opts.Writer, handlerOpts)
+		}
+	default:
+		if len(opts.TimeFormat) == 0 {
+			opts.TimeFormat = time.RFC3339
+		}
+		h = tint.NewHandler(opts.Writer, &tint.Options{
+			AddSource:  opts.AddSource,
+			Level:      minLevel,
+			TimeFormat: opts.TimeFormat,
+		})
+	}
+	base.Store(&generation{handler: h})
+}
+
+func formatTime(layout string) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey && len(groups) == 0 && a.Value.Kind() == slog.KindTime {
+			a.Value = slog.StringValue(a.Value.Time().Format(layout))
+		}
+		return a
+	}
+}
 
 func Logger() *slog.Logger {
-	if logger != nil {
-		return logger
-	}
-	if Leveler == nil && os.Getenv("NSX_MODE") != "release" {
-		Leveler = slog.LevelDebug
-	} else {
-		Leveler = slog.LevelInfo
-	}
-	logger = slog.New(tint.NewHandler(os.Stderr, &tint.Options{
-		Level:      Leveler,
-		TimeFormat: time.Kitchen,
-	}))
 	return logger
 }
 
+// Component returns a logger with a component attribute whose level can be set by SetComponentLevel,
+// such as "driver", "engine" or a plugin name.
+func Component(name string) *slog.Logger {
+	return slog.New(&handler{component: name}).With("component", name)
+}
+
+// SetLevel changes the global level at runtime.
 func SetLevel(newLevel slog.Leveler) {
-	Leveler = newLevel
+	level.Set(newLevel.Level())
+	if Leveler != slog.Leveler(level) {
+		Leveler = level
+	}
+}
+
+func Level() slog.Level {
+	if Leveler == nil {
+		return level.Level()
+	}
+	return Leveler.Level()
 }
 
+// SetComponentLevel overrides the global level for the component name at runtime.
+func SetComponentLevel(name string, newLevel slog.Leveler) {
+	v, _ := components.LoadOrStore(name, new(slog.LevelVar))
+	v.(*slog.LevelVar).Set(newLevel.Level())
+}
+
+// ResetComponentLevel makes the component follow the global level again.
+func ResetComponentLevel(name string) {
+	components.Delete(name)
+}
+
+// SetWriter keeps the current options and changes the output.
 func SetWriter(w io.Writer) {
-	logger = slog.New(tint.NewHandler(w, &tint.Options{
-		Level:      Leveler,
-		TimeFormat: time.Kitchen,
-	}))
+	mu.Lock()
+	opts := options
+	mu.Unlock()
+	opts.Writer = w
+	Setup(opts)
+}
+
+// handler resolves the current base handler on every record, so Setup affects existing loggers.
+type handler struct {
+	component string
+	ops       []func(slog.Handler) slog.Handler
+	cache     atomic.Pointer[cached]
+}
+
+type cached struct {
+	gen     *generation
+	handler slog.Handler
+}
+
+func (h *handler) level() slog.Level {
+	if len(h.component) != 0 {
+		if v, ok := components.Load(h.component); ok {
+			return v.(*slog.LevelVar).Level()
+		}
+	}
+	return Level()
+}
+
+func (h *handler) resolve() slog.Handler {
+	gen := base.Load()
+	if c := h.cache.Load(); c != nil && c.gen == gen {
+		return c.handler
+	}
+	resolved := gen.handler
+	for _, op := range h.ops {
+		resolved = op(resolved)
+	}
+	h.cache.Store(&cached{gen: gen, handler: resolved})
+	return resolved
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.resolve().Handle(ctx, r)
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{component: h.component, ops: append(ops, op)}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler {
+		return next.WithAttrs(attrs)
+	})
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler {
+		return next.WithGroup(name)
+	})
 }
@@ -0,0 +1,114 @@
+package nlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupAndLevels(t *testing.T) {
+	defer Setup(Options{})
+	defer SetLevel(Level())
+
+	var buf bytes.Buffer
+	log := Component("driver")
+	Setup(Options{Format: FormatJSON, Writer: &buf})
+	SetLevel(slog.LevelInfo)
+
+	log.Info("hello", "k", "v")
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "hello", record["msg"])
+	assert.Equal(t, "driver", record["component"])
+	_, err := time.Parse(time.RFC3339, record["time"].(string))
+	assert.NoError(t, err)
+
+	buf.Reset()
+	log.Debug("hidden")
+	assert.Empty(t, buf.String())
+
+	SetComponentLevel("driver", slog.LevelDebug)
+	defer ResetComponentLevel("driver")
+	log.Debug("shown")
+	assert.Contains(t, buf.String(), "shown")
+
+	buf.Reset()
+	Logger().Debug("hidden")
+	assert.Empty(t, buf.String())
+}
+
+func TestRotateWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewRotateWriter(RotateOptions{
+		Filename:   filepath.Join(dir, "bot.log"),
+		MaxSize:    10,
+		MaxBackups: 2,
+	})
+	assert.NoError(t, err)
+	defer w.Close()
+	for range 5 {
+		_, err := w.Write([]byte("12345678\n"))
+		assert.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, "bot-*.log"))
+	assert.NoError(t, err)
+	assert.Len(t, backups, 2)
+	content, err := os.ReadFile(filepath.Join(dir, "bot.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678\n", string(content))
+}
+
+func TestRotateRenameFailure(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bot.log")
+	w, err := NewRotateWriter(RotateOptions{Filename: name})
+	assert.NoError(t, err)
+	defer w.Close()
+	// the rename fails without the file
+	assert.NoError(t, os.Remove(name))
+	assert.Error(t, w.Rotate())
+	_, err = w.Write([]byte("after\n"))
+	assert.NoError(t, err)
+	content, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, "after\n", string(content))
+}
+
+func TestDeprecatedLeveler(t *testing.T) {
+	defer Setup(Options{})
+	defer SetLevel(Level())
+
+	var buf bytes.Buffer
+	Setup(Options{Format: FormatJSON, Writer: &buf})
+	SetLevel(slog.LevelInfo)
+	Leveler = slog.LevelWarn
+	assert.Equal(t, slog.LevelWarn, Level())
+	Logger().Info("hidden")
+	assert.Empty(t, buf.String())
+
+	// SetLevel takes over again
+	SetLevel(slog.LevelDebug)
+	assert.Equal(t, slog.Leveler(level), Leveler)
+	Logger().Debug("shown")
+	assert.Contains(t, buf.String(), "shown")
+}
+
+func TestTextTimeFormat(t *testing.T) {
+	defer Setup(Options{})
+
+	var buf bytes.Buffer
+	Setup(Options{Format: FormatText, Writer: &buf})
+	Logger().Warn("hello")
+	assert.Contains(t, buf.String(), time.Now().Format("2006-01-02T"))
+
+	buf.Reset()
+	Setup(Options{Format: FormatText, Writer: &buf, TimeFormat: time.Kitchen})
+	Logger().Warn("hello")
+	assert.NotContains(t, buf.String(), time.Now().Format("2006-01-02T"))
+}
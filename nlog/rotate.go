@@ -0,0 +1,152 @@
+package nlog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+type RotateOptions struct {
+	Filename string
+	// rotate when the file would exceed MaxSize bytes, 0 disables size rotation
+	MaxSize int64
+	// rotate when the file is older than MaxAge, 0 disables age rotation
+	MaxAge time.Duration
+	// keep at most MaxBackups rotated files, 0 keeps all
+	MaxBackups int
+	// remove rotated files older than Retention, 0 keeps all
+	Retention time.Duration
+}
+
+// RotateWriter is a file writer with size and age based rotation,
+// rotated files are named name-<time>.ext next to Filename.
+type RotateWriter struct {
+	mu       sync.Mutex
+	opts     RotateOptions
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func NewRotateWriter(opts RotateOptions) (*RotateWriter, error) {
+	w := &RotateWriter{opts: opts}
+	if err := os.MkdirAll(filepath.Dir(opts.Filename), 0o755); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	file, err := os.OpenFile(w.opts.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = info.ModTime()
+	if w.size == 0 {
+		w.openedAt = time.Now()
+	}
+	return nil
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.size > 0 && ((w.opts.MaxSize > 0 && w.size+int64(len(p)) > w.opts.MaxSize) ||
+		(w.opts.MaxAge > 0 && time.Since(w.openedAt) > w.opts.MaxAge)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, renames it to a backup and opens a new one.
+func (w *RotateWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+func (w *RotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	ext := filepath.Ext(w.opts.Filename)
+	prefix := strings.TrimSuffix(w.opts.Filename, ext) + "-"
+	if err := os.Rename(w.opts.Filename, prefix+time.Now().Format(backupTimeFormat)+ext); err != nil {
+		// keep writing to the current file
+		if openErr := w.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.cleanup(prefix, ext)
+	return nil
+}
+
+func (w *RotateWriter) cleanup(prefix, ext string) {
+	if w.opts.MaxBackups <= 0 && w.opts.Retention <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, name := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	// the time format sorts from old to new
+	slices.Sort(backups)
+	for i, name := range backups {
+		tooMany := w.opts.MaxBackups > 0 && len(backups)-i > w.opts.MaxBackups
+		tooOld := false
+		if w.opts.Retention > 0 {
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > w.opts.Retention {
+				tooOld = true
+			}
+		}
+		if tooMany || tooOld {
+			os.Remove(name)
+		}
+	}
+}
+
+func (w *RotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,28 @@
+package nlog
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+)
+
+type traceKey struct{}
+
+// NewTraceId returns a random 16 hex digits trace id.
+func NewTraceId() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// WithTraceId returns a copy of ctx carrying the trace id.
+func WithTraceId(ctx context.Context, traceId string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceId)
+}
+
+// TraceId returns the trace id carried by ctx, or an empty string.
+func TraceId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	traceId, _ := ctx.Value(traceKey{}).(string)
+	return traceId
+}
@@ -0,0 +1,151 @@
+package nsxbot
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/nsxdevx/nsxbot/event"
+	"github.com/tidwall/gjson"
+)
+
+// OrderBy decides which events of an EventHandler are processed serially in arrival order.
+type OrderBy int
+
+const (
+	// every handler runs as soon as possible, the default
+	OrderNone OrderBy = iota
+	// events of the same group, events without group run in parallel
+	OrderByGroup
+	// events of the same user
+	OrderByUser
+	// events of the same group, or of the same user outside groups
+	OrderByChat
+)
+
+func (o OrderBy) key(event event.Event) string {
+	group := gjson.GetBytes(event.RawData, "group_id")
+	user := gjson.GetBytes(event.RawData, "user_id")
+	switch o {
+	case OrderByGroup:
+		if group.Exists() {
+			return "g" + group.String()
+		}
+	case OrderByUser:
+		if user.Exists() {
+			return "u" + user.String()
+		}
+	case OrderByChat:
+		if group.Exists() {
+			return "g" + group.String()
+		}
+		if user.Exists() {
+			return "u" + user.String()
+		}
+	}
+	return ""
+}
+
+// SetOrdered processes the events sharing a key one after another in arrival order,
+// the handlers of an event run after all handlers of the previous event are done.
+// Events with different keys still run in parallel.
+func (h *EventHandler[T]) SetOrdered(by OrderBy) {
+	h.orderKey = func(event event.Event) string {
+		return by.key(event)
+	}
+}
+
+// SetOrderedKey is like SetOrdered with a custom key, an empty key is not ordered.
+func (h *EventHandler[T]) SetOrderedKey(key func(msg T) string) {
+	h.orderKey = func(event event.Event) string {
+		var msg T
+		if err := json.Unmarshal(event.RawData, &msg); err != nil {
+			return ""
+		}
+		return key(msg)
+	}
+}
+
+// ticket is the place of an event in the queue of its key.
+type ticket struct {
+	wait <-chan struct{}
+	once sync.Once
+	done func()
+}
+
+func (t *ticket) release() {
+	if t != nil {
+		t.once.Do(t.done)
+	}
+}
+
+// orderer keeps the last ticket of every key, a key is removed when its last ticket is released.
+type orderer struct {
+	mu    sync.Mutex
+	tails map[string]chan struct{}
+}
+
+func (o *orderer) reserve(key string) *ticket {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.tails == nil {
+		o.tails = make(map[string]chan struct{})
+	}
+	wait := o.tails[key]
+	if wait == nil {
+		ready := make(chan struct{})
+		close(ready)
+		wait = ready
+	}
+	cur := make(chan struct{})
+	o.tails[key] = cur
+	return &ticket{
+		wait: wait,
+		done: func() {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			close(cur)
+			if o.tails[key] == cur {
+				delete(o.tails, key)
+			}
+		},
+	}
+}
+
+func (h *EventHandler[T]) reserve(event event.Event) *ticket {
+	if h.orderKey == nil {
+		return nil
+	}
+	key := h.orderKey(event)
+	if len(key) == 0 {
+		return nil
+	}
+	return h.orderer.reserve(strconv.FormatInt(event.SelfId, 10) + ":" + key)
+}
+
+// tickets of an event for every ordered consumer, by event type.
+type tickets map[string]*ticket
+
+func (t tickets) release() {
+	for _, ticket := range t {
+		ticket.release()
+	}
+}
+
+// reserve is called in arrival order by the single goroutine moving events into the task queue.
+func (e *Engine) reserve(event event.Event) tickets {
+	var reserved tickets
+	for _, Type := range event.Types {
+		consumer, ok := e.consumer(Type)
+		if !ok {
+			continue
+		}
+		if ticket := consumer.reserve(event); ticket != nil {
+			if reserved == nil {
+				reserved = make(tickets)
+			}
+			reserved[Type] = ticket
+		}
+	}
+	return reserved
+}
@@ -0,0 +1,197 @@
+package nsxbot
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/nsxdevx/nsxbot/metrics"
+	"github.com/nsxdevx/nsxbot/nlog"
+)
+
+var (
+	eventsDropped = metrics.Default.Counter("nsxbot_events_dropped_total",
+		"Events dropped by a full queue by pool, event type and reason.", "pool", "type", "reason")
+	poolQueueLength = metrics.Default.Gauge("nsxbot_pool_queue_length",
+		"Tasks waiting in a worker pool.", "pool")
+)
+
+// OverflowPolicy decides what happens to a task submitted to a full queue.
+type OverflowPolicy int
+
+const (
+	// wait for a free slot, slowing down the listener
+	OverflowBlock OverflowPolicy = iota
+	// drop the oldest waiting task to make room
+	OverflowDropOldest
+	// drop the submitted task
+	OverflowDropNewest
+	// drop the submitted task if its event type is one of DropTypes, otherwise wait
+	OverflowDropByType
+)
+
+type PoolConfig struct {
+	// number of workers, <= 0 starts a goroutine per task without queue
+	Workers int
+	// number of waiting tasks, <= 0 means Workers
+	QueueSize int
+	Overflow  OverflowPolicy
+	// event types such as "notice" or "message:group", used by OverflowDropByType
+	DropTypes []string
+}
+
+type poolTask struct {
+	types []string
+	run   func()
+	// release is called after run or when the task is dropped
+	release func()
+}
+
+// Pool is a bounded worker pool, it can be shared by several EventHandler or Composer.
+type Pool struct {
+	name     string
+	cfg      PoolConfig
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queue    []poolTask
+	closed   bool
+	start    sync.Once
+	workers  sync.WaitGroup
+	log      *slog.Logger
+}
+
+func NewPool(name string, cfg PoolConfig) *Pool {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = max(cfg.Workers, 1)
+	}
+	p := &Pool{
+		name: name,
+		cfg:  cfg,
+		log:  nlog.Component("engine").With("pool", name),
+	}
+	p.notEmpty = sync.NewCond(&p.mu)
+	p.notFull = sync.NewCond(&p.mu)
+	return p
+}
+
+// Len returns the number of waiting tasks.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// submit queues run following the overflow policy, it returns false if the task was dropped.
+func (p *Pool) submit(types []string, run func(), release func()) bool {
+	task := poolTask{types: types, run: run, release: release}
+	if p.cfg.Workers <= 0 {
+		go p.exec(task)
+		return true
+	}
+	p.start.Do(func() {
+		for range p.cfg.Workers {
+			p.workers.Add(1)
+			go p.work()
+		}
+	})
+
+	p.mu.Lock()
+	for len(p.queue) >= p.cfg.QueueSize && !p.closed {
+		switch p.cfg.Overflow {
+		case OverflowDropOldest:
+			oldest := p.queue[0]
+			p.queue = p.queue[1:]
+			p.mu.Unlock()
+			p.drop(oldest, "oldest")
+			p.mu.Lock()
+			continue
+		case OverflowDropNewest:
+			p.mu.Unlock()
+			p.drop(task, "newest")
+			return false
+		case OverflowDropByType:
+			if slices.ContainsFunc(types, func(t string) bool { return slices.Contains(p.cfg.DropTypes, t) }) {
+				p.mu.Unlock()
+				p.drop(task, "type")
+				return false
+			}
+		}
+		p.notFull.Wait()
+	}
+	if p.closed {
+		p.mu.Unlock()
+		p.drop(task, "closed")
+		return false
+	}
+	p.queue = append(p.queue, task)
+	poolQueueLength.Set(float64(len(p.queue)), p.name)
+	p.notEmpty.Signal()
+	p.mu.Unlock()
+	return true
+}
+
+func (p *Pool) drop(task poolTask, reason string) {
+	var typ string
+	if len(task.types) != 0 {
+		typ = task.types[len(task.types)-1]
+	}
+	eventsDropped.Inc(p.name, typ, reason)
+	p.log.Warn("Task dropped", "type", typ, "reason", reason)
+	if task.release != nil {
+		task.release()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.notEmpty.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		task := p.queue[0]
+		p.queue = p.queue[1:]
+		poolQueueLength.Set(float64(len(p.queue)), p.name)
+		p.notFull.Signal()
+		p.mu.Unlock()
+		p.exec(task)
+	}
+}
+
+func (p *Pool) exec(task poolTask) {
+	if task.release != nil {
+		defer task.release()
+	}
+	task.run()
+}
+
+// close stops accepting tasks, the workers exit after the waiting tasks are done.
+func (p *Pool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.notEmpty.Broadcast()
+	p.notFull.Broadcast()
+	p.mu.Unlock()
+	p.workers.Wait()
+}
+
+// SetOverflow sets the policy of the engine task queue, whose size is set by SetTaskLen, default is OverflowBlock.
+func (e *Engine) SetOverflow(policy OverflowPolicy, dropTypes ...string) {
+	e.overflow = policy
+	e.dropTypes = dropTypes
+}
+
+// detach runs fn outside of the pool, for handlers waiting for later events such as conversations,
+// otherwise they hold a worker the later events need.
+func (e *Engine) detach(fn func()) {
+	e.inflight.Add(1)
+	go func() {
+		defer e.inflight.Done()
+		fn()
+	}()
+}
@@ -0,0 +1,86 @@
+package nsxbot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// busyPool returns a pool with a busy worker and a full queue holding the task "queued",
+// release lets the worker go on.
+func busyPool(policy OverflowPolicy, dropTypes ...string) (p *Pool, ran func() []string, release func()) {
+	p = NewPool("test", PoolConfig{Workers: 1, QueueSize: 1, Overflow: policy, DropTypes: dropTypes})
+	var mu sync.Mutex
+	var names []string
+	started, unblock := make(chan struct{}), make(chan struct{})
+	p.submit(nil, func() {
+		close(started)
+		<-unblock
+	}, nil)
+	<-started
+	p.submit([]string{"message"}, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		names = append(names, "queued")
+	}, nil)
+	ran = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return names
+	}
+	return p, ran, func() {
+		close(unblock)
+	}
+}
+
+func TestPoolOverflow(t *testing.T) {
+	submit := func(p *Pool, typ string, ran *[]string, released *bool) bool {
+		return p.submit([]string{typ}, func() { *ran = append(*ran, typ) }, func() { *released = true })
+	}
+
+	p, ran, release := busyPool(OverflowDropNewest)
+	var newest []string
+	var released bool
+	assert.False(t, submit(p, "message", &newest, &released))
+	assert.True(t, released)
+	release()
+	p.close()
+	assert.Equal(t, []string{"queued"}, ran())
+	assert.Empty(t, newest)
+
+	p, ran, release = busyPool(OverflowDropOldest)
+	released = false
+	assert.True(t, submit(p, "message", &newest, &released))
+	release()
+	p.close()
+	assert.Empty(t, ran())
+	assert.Equal(t, []string{"message"}, newest)
+	assert.True(t, released)
+
+	p, ran, release = busyPool(OverflowDropByType, "notice")
+	newest, released = nil, false
+	assert.False(t, submit(p, "notice", &newest, &released))
+	assert.True(t, released)
+	release()
+	p.close()
+	assert.Equal(t, []string{"queued"}, ran())
+}
+
+func TestPoolOverflowBlock(t *testing.T) {
+	p, ran, release := busyPool(OverflowBlock)
+	submitted := make(chan bool)
+	go func() {
+		submitted <- p.submit([]string{"message"}, func() {}, nil)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("submit did not wait for the full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	assert.True(t, <-submitted)
+	p.close()
+	assert.Equal(t, []string{"queued"}, ran())
+}
@@ -1,12 +1,187 @@
 package nsxbot
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/schema"
+	"github.com/tidwall/gjson"
+)
+
+// Recovery recovers handler panics, it is installed by OnEvent, see Engine.OnPanic and Engine.SetPanicLimit.
 func Recovery[T any]() HandlerFunc[T] {
 	return func(ctx *Context[T]) {
 		defer func() {
 			if err := recover(); err != nil {
-				ctx.Log.Error("Handler Panic", "err", err, "time", ctx.Time, "selfId", ctx.SelfId)
+				ctx.recovered(err, debug.Stack())
 			}
 		}()
 		ctx.Next()
 	}
 }
+
+// PanicInfo describes a recovered handler panic.
+type PanicInfo struct {
+	// not canceled when the handler times out, so reporters can still send messages
+	context.Context
+	driver.Emitter
+
+	Recovered any
+	Stack     []byte
+	Route     string
+	SelfId    int64
+	TraceId   string
+	Time      time.Time
+	// the event without message content
+	Summary string
+	// the handler was paused by the panic limit
+	Disabled bool
+	Log      *slog.Logger
+}
+
+// PanicReporter is called after a handler panic was recovered and logged.
+type PanicReporter func(info *PanicInfo)
+
+// OnPanic adds panic reporters.
+func (e *Engine) OnPanic(reporters ...PanicReporter) {
+	e.panicReporters = append(e.panicReporters, reporters...)
+}
+
+// SetPanicLimit pauses a handler after limit panics within window, see Registration.Resume, 0 disables the limit.
+func (e *Engine) SetPanicLimit(limit int, window time.Duration) {
+	e.panicLimit = limit
+	e.panicWindow = window
+}
+
+type panicCount struct {
+	count int
+	since time.Time
+}
+
+// panicCounter counts the panics of every handler within the window of the panic limit,
+// handlers sharing a route are counted apart.
+type panicCounter struct {
+	mu       sync.Mutex
+	handlers map[*Registration]*panicCount
+}
+
+func (e *Engine) countPanic(reg *Registration) bool {
+	if e.panicLimit <= 0 {
+		return false
+	}
+	e.panics.mu.Lock()
+	defer e.panics.mu.Unlock()
+	if e.panics.handlers == nil {
+		e.panics.handlers = make(map[*Registration]*panicCount)
+	}
+	count, ok := e.panics.handlers[reg]
+	if !ok || time.Since(count.since) > e.panicWindow {
+		count = &panicCount{since: time.Now()}
+		e.panics.handlers[reg] = count
+	}
+	count.count++
+	if count.count < e.panicLimit {
+		return false
+	}
+	delete(e.panics.handlers, reg)
+	return true
+}
+
+func (c *Context[T]) recovered(value any, stack []byte) {
+	handlerPanics.Inc(c.route)
+	summary := summarize(c.Msg)
+	c.Log.Error("Handler Panic", "err", value, "time", c.Time, "event", summary, "stack", string(stack))
+	if c.engine == nil {
+		return
+	}
+	info := &PanicInfo{
+		Context:   context.WithoutCancel(c.Context),
+		Emitter:   c.Emitter,
+		Recovered: value,
+		Stack:     stack,
+		Route:     c.route,
+		SelfId:    c.SelfId,
+		TraceId:   c.TraceId(),
+		Time:      time.Now(),
+		Summary:   summary,
+		Log:       c.Log,
+	}
+	if c.registration != nil && c.engine.countPanic(c.registration) {
+		c.registration.Pause()
+		info.Disabled = true
+		c.Log.Warn("Handler paused after repeated panics", "limit", c.engine.panicLimit, "window", c.engine.panicWindow)
+	}
+	for _, reporter := range c.engine.panicReporters {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					c.Log.Error("Panic reporter panic", "err", err)
+				}
+			}()
+			reporter(info)
+		}()
+	}
+}
+
+// summarize describes the event of msg with its ids, the message content is left out.
+func summarize(msg any) string {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("%T", msg)
+	}
+	var fields []string
+	for _, key := range []string{"post_type", "message_type", "notice_type", "request_type", "sub_type", "group_id", "user_id", "message_id"} {
+		if value := gjson.GetBytes(data, key); value.Exists() && len(value.String()) != 0 {
+			fields = append(fields, key+"="+value.String())
+		}
+	}
+	if raw := gjson.GetBytes(data, "raw_message"); raw.Exists() {
+		fields = append(fields, fmt.Sprintf("raw_message=<%d chars>", len([]rune(raw.String()))))
+	}
+	return strings.Join(fields, " ")
+}
+
+// ReportPanics sends the panics to superUsers by private message.
+func ReportPanics(superUsers ...int64) PanicReporter {
+	return func(info *PanicInfo) {
+		if info.Emitter == nil {
+			return
+		}
+		text := fmt.Sprintf("panic: %v\nroute: %s\nselfId: %d\ntrace: %s\nevent: %s", info.Recovered, info.Route, info.SelfId, info.TraceId, info.Summary)
+		if info.Disabled {
+			text += "\nthe handler is paused"
+		}
+		var msg schema.MessageChain
+		msg = msg.Text(text)
+		for _, superUser := range superUsers {
+			if _, err := info.SendPvtMsg(info, superUser, msg); err != nil {
+				info.Log.Error("Report panic error", "superUser", superUser, "err", err)
+			}
+		}
+	}
+}
+
+// CrashDumps writes every panic with its stack to a file in dir.
+func CrashDumps(dir string) PanicReporter {
+	return func(info *PanicInfo) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			info.Log.Error("Crash dump error", "err", err)
+			return
+		}
+		name := filepath.Join(dir, fmt.Sprintf("crash-%s-%s.log", info.Time.Format("20060102T150405.000"), info.TraceId))
+		dump := fmt.Sprintf("time: %s\npanic: %v\nroute: %s\nselfId: %d\ntrace: %s\nevent: %s\ndisabled: %t\n\n%s",
+			info.Time.Format(time.RFC3339Nano), info.Recovered, info.Route, info.SelfId, info.TraceId, info.Summary, info.Disabled, info.Stack)
+		if err := os.WriteFile(name, []byte(dump), 0o644); err != nil {
+			info.Log.Error("Crash dump error", "err", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package nsxbot
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Registration is returned by Composer.Handle, it removes or pauses the handler at runtime.
+type Registration struct {
+	paused  atomic.Bool
+	once    sync.Once
+	removed atomic.Bool
+	remove  func()
+}
+
+// Remove unregisters the handler, running handlers are not interrupted.
+func (r *Registration) Remove() {
+	r.once.Do(func() {
+		r.removed.Store(true)
+		r.remove()
+	})
+}
+
+// Removed reports whether Remove was called.
+func (r *Registration) Removed() bool {
+	return r.removed.Load()
+}
+
+// Pause makes the handler skip events until Resume, a paused handler does not match.
+func (r *Registration) Pause() {
+	r.paused.Store(true)
+}
+
+func (r *Registration) Resume() {
+	r.paused.Store(false)
+}
+
+func (r *Registration) Paused() bool {
+	return r.paused.Load()
+}
+
+// Registrations groups the handlers of a plugin, to remove or pause them together.
+type Registrations []*Registration
+
+func (rs Registrations) Remove() {
+	for _, r := range rs {
+		r.Remove()
+	}
+}
+
+func (rs Registrations) Pause() {
+	for _, r := range rs {
+		r.Pause()
+	}
+}
+
+func (rs Registrations) Resume() {
+	for _, r := range rs {
+		r.Resume()
+	}
+}
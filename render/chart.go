@@ -0,0 +1,186 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(v, 'f', 2, 64), "0"), ".")
+}
+
+// truncate shortens s with an ellipsis to fit in width.
+func truncate(face font.Face, s string, width int) string {
+	if measure(face, s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) != 0 && measure(face, string(runes)+"…") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// Bar is a bar of a bar chart.
+type Bar struct {
+	Label string
+	Value float64
+}
+
+// BarChart draws horizontal bars with their labels and values, such as a leaderboard.
+func (r *Renderer) BarChart(title string, bars []Bar) image.Image {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	labelWidth, valueWidth := 0, 0
+	top := 0.0
+	for _, bar := range bars {
+		labelWidth = max(labelWidth, measure(r.regular, bar.Label))
+		valueWidth = max(valueWidth, measure(r.regular, formatValue(bar.Value)))
+		top = max(top, bar.Value)
+	}
+	labelWidth = min(labelWidth, r.content()/3)
+	barWidth := r.content() - labelWidth - valueWidth - 2*r.gap
+
+	lh := lineHeight(r.regular)
+	rowHeight := lh + r.gap
+	c := r.canvas(r.width, 2*r.pad+r.titleHeight(title)+len(bars)*rowHeight)
+	y := c.title(title, r.pad)
+	for i, bar := range bars {
+		c.text(r.regular, r.pad, y, truncate(r.regular, bar.Label, labelWidth), r.theme.Muted)
+		x := r.pad + labelWidth + r.gap
+		width := 0
+		if top > 0 && bar.Value > 0 {
+			width = int(float64(barWidth) * bar.Value / top)
+		}
+		c.rect(image.Rect(x, y+lh/6, x+width, y+lh-lh/6), r.theme.color(i))
+		c.text(r.regular, x+width+r.gap, y, formatValue(bar.Value), r.theme.Foreground)
+		y += rowHeight
+	}
+	return c
+}
+
+// Series is a line of a line chart.
+type Series struct {
+	Name   string
+	Values []float64
+}
+
+// line draws a line of thickness t from (x0, y0) to (x1, y1).
+func (c *canvas) line(x0, y0, x1, y1 float64, t int, col color.Color) {
+	steps := int(max(math.Abs(x1-x0), math.Abs(y1-y0))) + 1
+	for i := 0; i <= steps; i++ {
+		x := int(x0 + (x1-x0)*float64(i)/float64(steps))
+		y := int(y0 + (y1-y0)*float64(i)/float64(steps))
+		c.rect(image.Rect(x-t/2, y-t/2, x-t/2+t, y-t/2+t), col)
+	}
+}
+
+func (c *canvas) dot(x, y float64, radius int, col color.Color) {
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx*dx+dy*dy <= radius*radius {
+				c.Set(int(x)+dx, int(y)+dy, col)
+			}
+		}
+	}
+}
+
+// LineChart draws series over labels, such as daily message counts. The chart has a legend with several series.
+func (r *Renderer) LineChart(title string, labels []string, series ...Series) image.Image {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(labels)
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		n = max(n, len(s.Values))
+		for _, v := range s.Values {
+			low, high = min(low, v), max(high, v)
+		}
+	}
+	if low > high {
+		low, high = 0, 1
+	}
+	if low == high {
+		low, high = low-1, high+1
+	}
+
+	lh := lineHeight(r.regular)
+	const grid = 4
+	axisWidth := 0
+	for i := 0; i <= grid; i++ {
+		axisWidth = max(axisWidth, measure(r.regular, formatValue(low+(high-low)*float64(i)/grid)))
+	}
+	legendHeight := 0
+	if len(series) > 1 {
+		legendHeight = lh + r.gap
+	}
+	plotHeight := r.width / 2
+	c := r.canvas(r.width, 2*r.pad+r.titleHeight(title)+legendHeight+plotHeight+lh+r.gap)
+	y := c.title(title, r.pad)
+
+	if legendHeight != 0 {
+		x := r.pad
+		for i, s := range series {
+			c.rect(image.Rect(x, y+lh/4, x+lh/2, y+lh*3/4), r.theme.color(i))
+			x += lh/2 + r.gap/2
+			c.text(r.regular, x, y, s.Name, r.theme.Foreground)
+			x += measure(r.regular, s.Name) + r.pad
+		}
+		y += legendHeight
+	}
+
+	// the plot leaves half a line above the top grid line for its label
+	left, right := r.pad+axisWidth+r.gap, r.width-r.pad
+	plotTop, plotBottom := y+lh/2, y+plotHeight-lh/2
+	scaleY := func(v float64) float64 {
+		return float64(plotBottom) - (v-low)/(high-low)*float64(plotBottom-plotTop)
+	}
+	scaleX := func(i int) float64 {
+		if n <= 1 {
+			return float64(left+right) / 2
+		}
+		return float64(left) + float64(i)*float64(right-left)/float64(n-1)
+	}
+	for i := 0; i <= grid; i++ {
+		v := low + (high-low)*float64(i)/grid
+		gy := int(scaleY(v))
+		c.rect(image.Rect(left, gy, right, gy+1), r.theme.Border)
+		label := formatValue(v)
+		c.text(r.regular, left-r.gap-measure(r.regular, label), gy-lh/2, label, r.theme.Muted)
+	}
+
+	// skip labels so they do not overlap
+	labelWidth := 0
+	for _, label := range labels {
+		labelWidth = max(labelWidth, measure(r.regular, label))
+	}
+	every := 1
+	if n > 1 {
+		every = max(1, int(math.Ceil(float64(labelWidth+r.gap)*float64(n-1)/float64(right-left))))
+	}
+	for i := 0; i < len(labels); i += every {
+		width := measure(r.regular, labels[i])
+		x := min(max(int(scaleX(i))-width/2, r.pad), r.width-r.pad-width)
+		c.text(r.regular, x, y+plotHeight, labels[i], r.theme.Muted)
+	}
+
+	thickness := max(2, lh/10)
+	for i, s := range series {
+		col := r.theme.color(i)
+		for j, v := range s.Values {
+			if j != 0 {
+				c.line(scaleX(j-1), scaleY(s.Values[j-1]), scaleX(j), scaleY(v), thickness, col)
+			}
+			c.dot(scaleX(j), scaleY(v), thickness*2, col)
+		}
+	}
+	return c
+}
@@ -0,0 +1,244 @@
+// Package render draws text, tables, cards and charts into images in pure Go, ready to send as image segments.
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sync"
+
+	"github.com/nsxdevx/nsxbot/schema"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+type Option func(*config)
+
+type config struct {
+	theme   Theme
+	width   int
+	size    float64
+	regular []byte
+	bold    []byte
+}
+
+// Use theme, default is ThemeLight.
+func WithTheme(theme Theme) Option {
+	return func(c *config) {
+		c.theme = theme
+	}
+}
+
+// Draw images width pixels wide, default is 720.
+func WithWidth(width int) Option {
+	return func(c *config) {
+		c.width = width
+	}
+}
+
+// Draw text with size pixels, default is 20.
+func WithFontSize(size float64) Option {
+	return func(c *config) {
+		c.size = size
+	}
+}
+
+// Draw titles and table headers with the bold font, default is the font of New.
+func WithBoldFont(bold []byte) Option {
+	return func(c *config) {
+		c.bold = bold
+	}
+}
+
+// Renderer draws images, it is safe for concurrent use.
+type Renderer struct {
+	// the font faces keep state while drawing
+	mu      sync.Mutex
+	theme   Theme
+	width   int
+	pad     int
+	gap     int
+	regular font.Face
+	bold    font.Face
+	title   font.Face
+}
+
+// ErrNoFont is returned by New without font when the build has no default font.
+var ErrNoFont = errors.New("render: no font")
+
+// defaultFont is drawn by New without font. It is meant to be a CJK-capable subset embedded with go:embed,
+// such as Noto Sans SC, and stays empty until the subset is added to the tree.
+var defaultFont []byte
+
+// New returns a renderer drawing text with font, a TrueType or OpenType font or collection, nil for the default font.
+// The font must have the glyphs of the text, such as Noto Sans CJK for Chinese, Japanese and Korean.
+func New(font []byte, opts ...Option) (*Renderer, error) {
+	if len(font) == 0 {
+		font = defaultFont
+	}
+	if len(font) == 0 {
+		return nil, ErrNoFont
+	}
+	c := &config{
+		theme:   ThemeLight,
+		width:   720,
+		size:    20,
+		regular: font,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.bold == nil {
+		c.bold = c.regular
+	}
+	regular, err := parseFont(c.regular)
+	if err != nil {
+		return nil, err
+	}
+	bold, err := parseFont(c.bold)
+	if err != nil {
+		return nil, err
+	}
+	r := &Renderer{
+		theme: c.theme,
+		width: c.width,
+		pad:   int(c.size),
+		gap:   int(c.size / 2),
+	}
+	if r.regular, err = newFace(regular, c.size); err != nil {
+		return nil, err
+	}
+	if r.bold, err = newFace(bold, c.size); err != nil {
+		return nil, err
+	}
+	if r.title, err = newFace(bold, c.size*1.4); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func parseFont(data []byte) (*sfnt.Font, error) {
+	if bytes.HasPrefix(data, []byte("ttcf")) {
+		collection, err := opentype.ParseCollection(data)
+		if err != nil {
+			return nil, err
+		}
+		return collection.Font(0)
+	}
+	return opentype.Parse(data)
+}
+
+func newFace(f *sfnt.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+func lineHeight(face font.Face) int {
+	return face.Metrics().Height.Ceil()
+}
+
+// content returns the width inside the padding.
+func (r *Renderer) content() int {
+	return r.width - 2*r.pad
+}
+
+// titleHeight returns the height taken by title, 0 without title.
+func (r *Renderer) titleHeight(title string) int {
+	if len(title) == 0 {
+		return 0
+	}
+	return len(wrap(r.title, title, r.content()))*lineHeight(r.title) + r.gap
+}
+
+type canvas struct {
+	*image.RGBA
+	r *Renderer
+}
+
+func (r *Renderer) canvas(width int, height int) *canvas {
+	c := &canvas{RGBA: image.NewRGBA(image.Rect(0, 0, width, height)), r: r}
+	draw.Draw(c, c.Bounds(), image.NewUniform(r.theme.Background), image.Point{}, draw.Src)
+	return c
+}
+
+// text draws s with the top of its line at y.
+func (c *canvas) text(face font.Face, x int, y int, s string, col color.Color) {
+	d := font.Drawer{
+		Dst:  c,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.P(x, y+face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(s)
+}
+
+func (c *canvas) rect(rect image.Rectangle, col color.Color) {
+	draw.Draw(c, rect, image.NewUniform(col), image.Point{}, draw.Over)
+}
+
+// title draws the title at y and returns the y below it.
+func (c *canvas) title(title string, y int) int {
+	if len(title) == 0 {
+		return y
+	}
+	for _, line := range wrap(c.r.title, title, c.r.content()) {
+		c.text(c.r.title, c.r.pad, y, line, c.r.theme.Accent)
+		y += lineHeight(c.r.title)
+	}
+	return y + c.r.gap
+}
+
+// Text draws a title and text wrapped to the width, the title may be empty.
+func (r *Renderer) Text(title string, text string) image.Image {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := wrap(r.regular, text, r.content())
+	lh := lineHeight(r.regular)
+	c := r.canvas(r.width, 2*r.pad+r.titleHeight(title)+len(lines)*lh)
+	y := c.title(title, r.pad)
+	for _, line := range lines {
+		c.text(r.regular, r.pad, y, line, r.theme.Foreground)
+		y += lh
+	}
+	return c
+}
+
+// RenderMessage draws the text of msg into an image segment, the other segments are kept after it
+// and reply segments before it. Its method value can render long messages, see nsxbot.WithLongRenderer.
+func (r *Renderer) RenderMessage(ctx context.Context, msg schema.MessageChain) (schema.MessageChain, error) {
+	img, err := Message(r.Text("", msg.PlainText()))
+	if err != nil {
+		return nil, err
+	}
+	var rendered, rest schema.MessageChain
+	for _, seg := range msg {
+		switch seg.Type {
+		case "text":
+		case "reply":
+			rendered = append(rendered, seg)
+		default:
+			rest = append(rest, seg)
+		}
+	}
+	return append(append(rendered, img...), rest...), nil
+}
+
+// EncodePNG encodes img as PNG.
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Message returns a chain with img as base64 PNG image segment, see schema.MessageChain.ImageFrom for opts.
+func Message(img image.Image, opts ...schema.ImageOption) (schema.MessageChain, error) {
+	var msg schema.MessageChain
+	return msg.ImageFrom(img, opts...)
+}
@@ -0,0 +1,54 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestWrap(t *testing.T) {
+	r, err := New(goregular.TTF)
+	assert.NoError(t, err)
+	width := measure(r.regular, "hello world")
+	assert.Equal(t, []string{"hello world", "foo"}, wrap(r.regular, "hello world foo", width))
+	assert.Equal(t, []string{"a", "", "b"}, wrap(r.regular, "a\n\nb", width))
+
+	// CJK text breaks between characters, closing punctuation stays on its line
+	cjk := measure(r.regular, "你好")
+	assert.Equal(t, []string{"你好", "你好。", "世界"}, wrap(r.regular, "你好你好。世界", cjk))
+
+	// long words are broken anywhere
+	for _, line := range wrap(r.regular, strings.Repeat("x", 100), width) {
+		assert.LessOrEqual(t, measure(r.regular, line), width)
+	}
+}
+
+func TestDefaultFont(t *testing.T) {
+	defer func(font []byte) { defaultFont = font }(defaultFont)
+
+	defaultFont = nil
+	_, err := New(nil)
+	assert.ErrorIs(t, err, ErrNoFont)
+
+	// the font argument overrides the default
+	defaultFont = []byte("not a font")
+	_, err = New(goregular.TTF)
+	assert.NoError(t, err)
+	_, err = New(nil)
+	assert.Error(t, err)
+}
+
+func TestRender(t *testing.T) {
+	r, err := New(goregular.TTF, WithBoldFont(gobold.TTF), WithTheme(ThemeDark), WithWidth(400))
+	assert.NoError(t, err)
+	img := r.Table("rank", []string{"#", "name", "score"}, [][]string{{"1", "alice", "120"}, {"2", "bob"}})
+	assert.Equal(t, 400, img.Bounds().Dx())
+	msg, err := Message(img)
+	assert.NoError(t, err)
+	assert.Equal(t, "image", msg[0].Type)
+	assert.NotNil(t, r.LineChart("", []string{"mon"}, Series{Values: []float64{3}}))
+	assert.NotNil(t, r.BarChart("", nil))
+}
@@ -0,0 +1,149 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// widest returns the width of the widest line of s.
+func widest(face font.Face, s string) int {
+	width := 0
+	for _, line := range strings.Split(s, "\n") {
+		width = max(width, measure(face, line))
+	}
+	return width
+}
+
+// Table draws a table with a header row, cells are wrapped when the table is wider than the image.
+func (r *Renderer) Table(title string, header []string, rows [][]string) image.Image {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cols := len(header)
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	cell := func(row []string, i int) string {
+		if i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	// share the width by the natural width of the columns
+	natural := make([]int, cols)
+	total := 0
+	for i := range natural {
+		natural[i] = widest(r.bold, cell(header, i))
+		for _, row := range rows {
+			natural[i] = max(natural[i], widest(r.regular, cell(row, i)))
+		}
+		natural[i] += 2 * r.gap
+		total += natural[i]
+	}
+	widths := make([]int, cols)
+	used := 0
+	for i := range widths {
+		if i == cols-1 {
+			widths[i] = max(r.content()-used, 2*r.gap+measure(r.regular, "W"))
+			break
+		}
+		widths[i] = max(r.content()*natural[i]/max(total, 1), 2*r.gap+measure(r.regular, "W"))
+		used += widths[i]
+	}
+
+	lh := lineHeight(r.regular)
+	layout := func(face font.Face, row []string) ([][]string, int) {
+		lines := make([][]string, cols)
+		height := 1
+		for i := range lines {
+			lines[i] = wrap(face, cell(row, i), widths[i]-2*r.gap)
+			height = max(height, len(lines[i]))
+		}
+		return lines, height*lh + r.gap
+	}
+	headerLines, headerHeight := layout(r.bold, header)
+	bodyLines := make([][][]string, len(rows))
+	heights := make([]int, len(rows))
+	height := 2*r.pad + r.titleHeight(title)
+	if len(header) != 0 {
+		height += headerHeight + 2
+	}
+	for i, row := range rows {
+		bodyLines[i], heights[i] = layout(r.regular, row)
+		height += heights[i]
+	}
+
+	c := r.canvas(r.width, height)
+	y := c.title(title, r.pad)
+	drawRow := func(face font.Face, lines [][]string, y int, col color.Color) {
+		x := r.pad
+		for i, cellLines := range lines {
+			for j, line := range cellLines {
+				c.text(face, x+r.gap, y+r.gap/2+j*lh, line, col)
+			}
+			x += widths[i]
+		}
+	}
+	if len(header) != 0 {
+		drawRow(r.bold, headerLines, y, r.theme.Accent)
+		y += headerHeight
+		c.rect(image.Rect(r.pad, y, r.width-r.pad, y+2), r.theme.Accent)
+		y += 2
+	}
+	for i := range rows {
+		if i%2 == 1 {
+			c.rect(image.Rect(r.pad, y, r.width-r.pad, y+heights[i]), r.theme.Stripe)
+		}
+		drawRow(r.regular, bodyLines[i], y, r.theme.Foreground)
+		y += heights[i]
+	}
+	return c
+}
+
+// Field is a row of a card.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Card draws key/value rows, such as a profile or the stats of a group.
+func (r *Renderer) Card(title string, fields []Field) image.Image {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keyWidth := 0
+	for _, field := range fields {
+		keyWidth = max(keyWidth, widest(r.bold, field.Key))
+	}
+	keyWidth = min(keyWidth, r.content()*2/5)
+	valueWidth := r.content() - keyWidth - 2*r.gap
+
+	lh := lineHeight(r.regular)
+	keys := make([][]string, len(fields))
+	values := make([][]string, len(fields))
+	heights := make([]int, len(fields))
+	height := 2*r.pad + r.titleHeight(title)
+	for i, field := range fields {
+		keys[i] = wrap(r.bold, field.Key, keyWidth)
+		values[i] = wrap(r.regular, field.Value, valueWidth)
+		heights[i] = max(len(keys[i]), len(values[i]))*lh + r.gap
+		height += heights[i]
+	}
+
+	c := r.canvas(r.width, height)
+	y := c.title(title, r.pad)
+	for i := range fields {
+		if i != 0 {
+			c.rect(image.Rect(r.pad, y, r.width-r.pad, y+1), r.theme.Border)
+		}
+		for j, line := range keys[i] {
+			c.text(r.bold, r.pad, y+r.gap/2+j*lh, line, r.theme.Muted)
+		}
+		for j, line := range values[i] {
+			c.text(r.regular, r.pad+keyWidth+2*r.gap, y+r.gap/2+j*lh, line, r.theme.Foreground)
+		}
+		y += heights[i]
+	}
+	return c
+}
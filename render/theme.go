@@ -0,0 +1,57 @@
+package render
+
+import "image/color"
+
+// Theme holds the colors of the rendered images.
+type Theme struct {
+	Background color.Color
+	Foreground color.Color
+	// keys, axis labels and other secondary text
+	Muted color.Color
+	// titles and table headers
+	Accent color.Color
+	Border color.Color
+	// background of every other table row
+	Stripe color.Color
+	// colors of bars and chart series, used in turn
+	Palette []color.Color
+}
+
+var ThemeLight = Theme{
+	Background: color.RGBA{0xff, 0xff, 0xff, 0xff},
+	Foreground: color.RGBA{0x1f, 0x23, 0x28, 0xff},
+	Muted:      color.RGBA{0x65, 0x6d, 0x76, 0xff},
+	Accent:     color.RGBA{0x09, 0x69, 0xda, 0xff},
+	Border:     color.RGBA{0xd0, 0xd7, 0xde, 0xff},
+	Stripe:     color.RGBA{0xf6, 0xf8, 0xfa, 0xff},
+	Palette: []color.Color{
+		color.RGBA{0x09, 0x69, 0xda, 0xff},
+		color.RGBA{0x1a, 0x7f, 0x37, 0xff},
+		color.RGBA{0xbf, 0x87, 0x00, 0xff},
+		color.RGBA{0xcf, 0x22, 0x2e, 0xff},
+		color.RGBA{0x82, 0x50, 0xdf, 0xff},
+	},
+}
+
+var ThemeDark = Theme{
+	Background: color.RGBA{0x0d, 0x11, 0x17, 0xff},
+	Foreground: color.RGBA{0xe6, 0xed, 0xf3, 0xff},
+	Muted:      color.RGBA{0x8d, 0x96, 0xa0, 0xff},
+	Accent:     color.RGBA{0x58, 0xa6, 0xff, 0xff},
+	Border:     color.RGBA{0x30, 0x36, 0x3d, 0xff},
+	Stripe:     color.RGBA{0x16, 0x1b, 0x22, 0xff},
+	Palette: []color.Color{
+		color.RGBA{0x58, 0xa6, 0xff, 0xff},
+		color.RGBA{0x3f, 0xb9, 0x50, 0xff},
+		color.RGBA{0xd2, 0x99, 0x22, 0xff},
+		color.RGBA{0xf8, 0x51, 0x49, 0xff},
+		color.RGBA{0xbc, 0x8c, 0xff, 0xff},
+	},
+}
+
+func (t Theme) color(i int) color.Color {
+	if len(t.Palette) == 0 {
+		return t.Accent
+	}
+	return t.Palette[i%len(t.Palette)]
+}
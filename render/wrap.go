@@ -0,0 +1,86 @@
+package render
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+)
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303f) || (r >= 0xff00 && r <= 0xffef)
+}
+
+// noLineStart are the closing punctuation marks which must not start a line.
+const noLineStart = "，。、；：！？）」』】》〉”’"
+
+// tokens splits s into the units a line can break between: CJK characters, runs of spaces and words.
+func tokens(s string) []string {
+	var tokens []string
+	var word strings.Builder
+	var space bool
+	flush := func() {
+		if word.Len() != 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case isCJK(r) || strings.ContainsRune(noLineStart, r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r) != space:
+			flush()
+			space = !space
+			word.WriteRune(r)
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func measure(face font.Face, s string) int {
+	return font.MeasureString(face, s).Ceil()
+}
+
+// wrap breaks text into lines not wider than width, between CJK characters and at spaces.
+// Longer words are broken anywhere.
+func wrap(face font.Face, text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		var line string
+		for _, token := range tokens(strings.TrimRight(paragraph, "\r")) {
+			if measure(face, line+token) <= width {
+				line += token
+				continue
+			}
+			if r, size := utf8.DecodeRuneInString(token); len(line) != 0 && size == len(token) && strings.ContainsRune(noLineStart, r) {
+				// punctuation stays on the line it closes
+				line += token
+				continue
+			}
+			if len(line) != 0 {
+				lines = append(lines, strings.TrimRight(line, " \t"))
+				line = strings.TrimLeft(token, " \t")
+			} else {
+				line = token
+			}
+			for measure(face, line) > width {
+				runes := []rune(line)
+				n := 1
+				for n < len(runes) && measure(face, string(runes[:n+1])) <= width {
+					n++
+				}
+				lines = append(lines, string(runes[:n]))
+				line = string(runes[n:])
+			}
+		}
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	return lines
+}
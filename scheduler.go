@@ -0,0 +1,217 @@
+package nsxbot
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/cron"
+	"github.com/nsxdevx/nsxbot/driver"
+	"github.com/nsxdevx/nsxbot/nlog"
+	"github.com/nsxdevx/nsxbot/storage"
+)
+
+// OfflinePolicy decides what happens to a run when the bot is offline.
+type OfflinePolicy int
+
+const (
+	// skip the run, the job waits for its next activation
+	OfflineSkip OfflinePolicy = iota
+	// keep the run and start it as soon as the bot is online again, until the next activation
+	OfflineQueue
+)
+
+// JobContext is passed to a job run for one bot.
+type JobContext struct {
+	context.Context
+	// nil for jobs without WithJobSelfIds, which cannot send messages
+	driver.Emitter
+
+	// the activation time of this run
+	Time   time.Time
+	SelfId int64
+	Log    *slog.Logger
+
+	storage storage.Storage
+}
+
+// Bucket returns the bucket called name of the engine storage, see Engine.SetStorage.
+func (c *JobContext) Bucket(name string) storage.Bucket {
+	return c.storage.Bucket(name)
+}
+
+type JobFunc func(ctx *JobContext)
+
+type Job struct {
+	name     string
+	schedule cron.Schedule
+	fn       JobFunc
+	selfIds  []int64
+	location *time.Location
+	offline  OfflinePolicy
+	retry    time.Duration
+	running  sync.Map // selfId -> *atomic.Bool
+	engine   *Engine
+}
+
+type JobOption func(*Job)
+
+// Run the job for the bots, without bots the job runs once per activation with a nil Emitter,
+// calling the methods of JobContext.Emitter then panics.
+func WithJobSelfIds(selfIds ...int64) JobOption {
+	return func(j *Job) {
+		j.selfIds = selfIds
+	}
+}
+
+// Evaluate the schedule in loc, default is time.Local. A CRON_TZ prefix in the expression takes precedence.
+func WithJobLocation(loc *time.Location) JobOption {
+	return func(j *Job) {
+		j.location = loc
+	}
+}
+
+// Set what to do when the bot is offline, retry is the interval to check whether the bot is back online.
+func WithJobOffline(policy OfflinePolicy, retry time.Duration) JobOption {
+	return func(j *Job) {
+		j.offline = policy
+		j.retry = retry
+	}
+}
+
+// Set the job name used in logs.
+func WithJobName(name string) JobOption {
+	return func(j *Job) {
+		j.name = name
+	}
+}
+
+// Cron schedules job with a cron expression, see cron.Parse for the syntax.
+func (e *Engine) Cron(spec string, job JobFunc, opts ...JobOption) (*Job, error) {
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]JobOption{WithJobName(spec)}, opts...)
+	return e.Schedule(schedule, job, opts...), nil
+}
+
+// Every schedules job at a fixed interval.
+func (e *Engine) Every(interval time.Duration, job JobFunc, opts ...JobOption) *Job {
+	opts = append([]JobOption{WithJobName("@every " + interval.String())}, opts...)
+	return e.Schedule(cron.Every(interval), job, opts...)
+}
+
+// Schedule adds a job to the engine, jobs start with Engine.Run.
+func (e *Engine) Schedule(schedule cron.Schedule, fn JobFunc, opts ...JobOption) *Job {
+	job := &Job{
+		schedule: schedule,
+		fn:       fn,
+		location: time.Local,
+		retry:    10 * time.Second,
+		engine:   e,
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+	e.jobmu.Lock()
+	defer e.jobmu.Unlock()
+	e.jobs = append(e.jobs, job)
+	if e.jobctx != nil {
+		go job.start(e.jobctx, e.jobRunCtx)
+	}
+	return job
+}
+
+// startJobs schedules the jobs until ctx is done, the runs get runCtx which outlives ctx until the shutdown timeout.
+func (e *Engine) startJobs(ctx context.Context, runCtx context.Context) {
+	e.jobmu.Lock()
+	defer e.jobmu.Unlock()
+	e.jobctx = ctx
+	e.jobRunCtx = runCtx
+	for _, job := range e.jobs {
+		go job.start(ctx, runCtx)
+	}
+}
+
+func (j *Job) start(ctx context.Context, runCtx context.Context) {
+	log := j.engine.log.With("job", j.name)
+	for {
+		next := j.schedule.Next(time.Now().In(j.location))
+		if next.IsZero() {
+			log.Warn("Job has no next activation, stopped")
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if len(j.selfIds) == 0 {
+			go j.run(runCtx, log, nil, 0, next)
+			continue
+		}
+		for _, selfId := range j.selfIds {
+			go j.runSelf(ctx, runCtx, log, selfId, next)
+		}
+	}
+}
+
+func (j *Job) runSelf(ctx context.Context, runCtx context.Context, log *slog.Logger, selfId int64, at time.Time) {
+	log = log.With("selfId", selfId)
+	emitter, err := j.engine.emitter(selfId)
+	if err != nil && j.offline == OfflineQueue {
+		log.Warn("Bot offline, job queued", "err", err)
+		// give up when the next activation is due, it will run then
+		deadline := j.schedule.Next(at)
+		ticker := time.NewTicker(j.retry)
+		defer ticker.Stop()
+		for err != nil && (deadline.IsZero() || time.Now().Before(deadline)) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				emitter, err = j.engine.emitter(selfId)
+			}
+		}
+	}
+	if err != nil {
+		log.Warn("Bot offline, job skipped", "err", err)
+		return
+	}
+	j.run(runCtx, log, emitter, selfId, at)
+}
+
+func (j *Job) run(ctx context.Context, log *slog.Logger, emitter driver.Emitter, selfId int64, at time.Time) {
+	if ctx.Err() != nil || !j.engine.track() {
+		return
+	}
+	defer j.engine.inflight.Done()
+	flag, _ := j.running.LoadOrStore(selfId, &atomic.Bool{})
+	running := flag.(*atomic.Bool)
+	if !running.CompareAndSwap(false, true) {
+		log.Warn("Job still running, activation skipped", "time", at)
+		return
+	}
+	defer running.Store(false)
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("Job Panic", "err", err, "time", at)
+		}
+	}()
+	traceId := nlog.NewTraceId()
+	log = log.With("trace", traceId)
+	log.Debug("Job run", "time", at)
+	j.fn(&JobContext{
+		Context: driver.WithSendPriority(nlog.WithTraceId(ctx, traceId), driver.PriorityBroadcast),
+		Emitter: emitter,
+		Time:    at,
+		SelfId:  selfId,
+		Log:     log,
+		storage: j.engine.storage,
+	})
+}
@@ -0,0 +1,42 @@
+package nsxbot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobAfterShutdown(t *testing.T) {
+	e := New(&fakeListener{}, &fakeMux{})
+	var runs int
+	job := e.Every(time.Hour, func(ctx *JobContext) { runs++ })
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, e.Run(ctx))
+
+	// a run starting after the shutdown is not started
+	job.run(context.Background(), e.log, nil, 0, time.Now())
+	assert.Zero(t, runs)
+}
+
+func TestJobContextOutlivesShutdownStart(t *testing.T) {
+	e := New(&fakeListener{}, &fakeMux{})
+	started := make(chan struct{})
+	var cancelled bool
+	e.Every(10*time.Millisecond, func(ctx *JobContext) {
+		select {
+		case started <- struct{}{}:
+		default:
+			return
+		}
+		// the shutdown has started, the run keeps its context while it finishes in time
+		time.Sleep(50 * time.Millisecond)
+		cancelled = ctx.Err() != nil
+	})
+	stop := start(t, e)
+	<-started
+	stop()
+	assert.False(t, cancelled)
+}
@@ -0,0 +1,219 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	// the formats compressed by WithImageCompress
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrImageTooLarge is returned when an image is larger than the size limit and may not be compressed.
+var ErrImageTooLarge = errors.New("image too large")
+
+// DefaultImageMaxSize is the default size limit of images sent as base64.
+const DefaultImageMaxSize = 10 << 20
+
+type ImageOption func(*imageOptions)
+
+type imageOptions struct {
+	format   string
+	quality  int
+	maxSize  int
+	compress bool
+	sharedFS bool
+}
+
+// Encode an image.Image as "png" or "jpeg", default is png.
+func WithImageFormat(format string) ImageOption {
+	return func(o *imageOptions) {
+		o.format = format
+	}
+}
+
+// Encode jpeg images with quality from 1 to 100, default is 90.
+func WithImageQuality(quality int) ImageOption {
+	return func(o *imageOptions) {
+		o.quality = quality
+	}
+}
+
+// Limit images to maxSize bytes, default is DefaultImageMaxSize. 0 is unlimited.
+func WithImageMaxSize(maxSize int) ImageOption {
+	return func(o *imageOptions) {
+		o.maxSize = maxSize
+	}
+}
+
+// Re-encode images larger than the size limit as jpeg with a lower quality and size, instead of ErrImageTooLarge.
+// Animated gifs lose their animation.
+func WithImageCompress() ImageOption {
+	return func(o *imageOptions) {
+		o.compress = true
+	}
+}
+
+// The backend runs on the same filesystem, ImageFile sends file:// URIs instead of reading the files.
+func WithImageSharedFS() ImageOption {
+	return func(o *imageOptions) {
+		o.sharedFS = true
+	}
+}
+
+func newImageOptions(opts []ImageOption) *imageOptions {
+	o := &imageOptions{
+		format:  "png",
+		quality: 90,
+		maxSize: DefaultImageMaxSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *imageOptions) encode(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg", "jpg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	default:
+		err = fmt.Errorf("unsupported image format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// limit returns data if it is within the size limit, or data compressed to fit in it.
+func (o *imageOptions) limit(data []byte, img image.Image) ([]byte, error) {
+	if o.maxSize <= 0 || len(data) <= o.maxSize {
+		return data, nil
+	}
+	if !o.compress {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrImageTooLarge, len(data), o.maxSize)
+	}
+	if img == nil {
+		var err error
+		if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	// jpeg has no transparency
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	img = flat
+	// lower the quality first, then the size
+	for _, quality := range []int{85, 70, 55} {
+		if data, err := o.encode(img, "jpeg", quality); err != nil || len(data) <= o.maxSize {
+			return data, err
+		}
+	}
+	for {
+		bounds := img.Bounds()
+		width, height := bounds.Dx()*3/4, bounds.Dy()*3/4
+		if width < 16 || height < 16 {
+			return nil, fmt.Errorf("%w: cannot be compressed to %d bytes", ErrImageTooLarge, o.maxSize)
+		}
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		img = scaled
+		if data, err := o.encode(img, "jpeg", 70); err != nil || len(data) <= o.maxSize {
+			return data, err
+		}
+	}
+}
+
+func (m MessageChain) base64Image(data []byte) MessageChain {
+	return m.Image("base64://" + base64.StdEncoding.EncodeToString(data))
+}
+
+// ImageFrom adds img encoded as base64, see WithImageFormat.
+func (m MessageChain) ImageFrom(img image.Image, opts ...ImageOption) (MessageChain, error) {
+	o := newImageOptions(opts)
+	data, err := o.encode(img, o.format, o.quality)
+	if err != nil {
+		return m, err
+	}
+	if data, err = o.limit(data, img); err != nil {
+		return m, err
+	}
+	return m.base64Image(data), nil
+}
+
+// ImageData adds the encoded image data as base64.
+func (m MessageChain) ImageData(data []byte, opts ...ImageOption) (MessageChain, error) {
+	data, err := newImageOptions(opts).limit(data, nil)
+	if err != nil {
+		return m, err
+	}
+	return m.base64Image(data), nil
+}
+
+// ImageReader adds the encoded image read from r as base64.
+// Without WithImageCompress reading stops at the size limit.
+func (m MessageChain) ImageReader(r io.Reader, opts ...ImageOption) (MessageChain, error) {
+	o := newImageOptions(opts)
+	if o.maxSize > 0 && !o.compress {
+		r = io.LimitReader(r, int64(o.maxSize)+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return m, err
+	}
+	if data, err = o.limit(data, nil); err != nil {
+		return m, err
+	}
+	return m.base64Image(data), nil
+}
+
+// ImageFile adds the local image file at path, as file:// URI with WithImageSharedFS or else as base64.
+// A shared file larger than the size limit is read and compressed with WithImageCompress.
+func (m MessageChain) ImageFile(path string, opts ...ImageOption) (MessageChain, error) {
+	o := newImageOptions(opts)
+	if o.sharedFS {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return m, err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return m, err
+		}
+		if o.maxSize <= 0 || info.Size() <= int64(o.maxSize) {
+			// rfc 8089, file:///C:/Users/1.png on windows
+			slashed := filepath.ToSlash(abs)
+			if !strings.HasPrefix(slashed, "/") {
+				slashed = "/" + slashed
+			}
+			return m.Image((&url.URL{Scheme: "file", Path: slashed}).String()), nil
+		}
+		if !o.compress {
+			return m, fmt.Errorf("%w: %d bytes, limit %d", ErrImageTooLarge, info.Size(), o.maxSize)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+	return m.ImageReader(f, opts...)
+}
@@ -0,0 +1,95 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func noise(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rand.N(256))
+	}
+	return img
+}
+
+func imageFile(t *testing.T, msg MessageChain) string {
+	var img Image
+	assert.NoError(t, json.Unmarshal(msg[len(msg)-1].Data, &img))
+	return img.File
+}
+
+func TestImageFrom(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.White)
+	msg, err := MessageChain{}.ImageFrom(img)
+	assert.NoError(t, err)
+	file := imageFile(t, msg)
+	assert.True(t, strings.HasPrefix(file, "base64://"))
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(file, "base64://"))
+	assert.NoError(t, err)
+	decoded, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+}
+
+func TestImageLimit(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, noise(256)))
+	data := buf.Bytes()
+
+	_, err := MessageChain{}.ImageData(data, WithImageMaxSize(16<<10))
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+	_, err = MessageChain{}.ImageReader(bytes.NewReader(data), WithImageMaxSize(16<<10))
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+
+	msg, err := MessageChain{}.ImageReader(bytes.NewReader(data), WithImageMaxSize(16<<10), WithImageCompress())
+	assert.NoError(t, err)
+	compressed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(imageFile(t, msg), "base64://"))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(compressed), 16<<10)
+}
+
+func TestImageCompressGIF(t *testing.T) {
+	src := noise(256)
+	img := image.NewPaletted(src.Bounds(), palette.Plan9)
+	draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	assert.NoError(t, gif.Encode(&buf, img, nil))
+	assert.Greater(t, buf.Len(), 16<<10)
+
+	msg, err := MessageChain{}.ImageData(buf.Bytes(), WithImageMaxSize(16<<10), WithImageCompress())
+	assert.NoError(t, err)
+	compressed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(imageFile(t, msg), "base64://"))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(compressed), 16<<10)
+}
+
+func TestImageFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a b.png")
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, noise(8)))
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	msg, err := MessageChain{}.ImageFile(path, WithImageSharedFS())
+	assert.NoError(t, err)
+	assert.Equal(t, "file://"+filepath.ToSlash(strings.ReplaceAll(path, " ", "%20")), imageFile(t, msg))
+
+	msg, err = MessageChain{}.ImageFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "base64://"+base64.StdEncoding.EncodeToString(buf.Bytes()), imageFile(t, msg))
+}
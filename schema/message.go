@@ -79,6 +79,13 @@ type Reply struct {
 	Id int `json:"id"`
 }
 
+// Node is a message of a merged-forward message.
+type Node struct {
+	UserId   string       `json:"user_id"`
+	Nickname string       `json:"nickname"`
+	Content  MessageChain `json:"content"`
+}
+
 var ErrNetWork = errors.New("network error")
 
 type CommonFile struct {
@@ -1,6 +1,10 @@
 package schema
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
 
 type MessageChain []Message
 
@@ -111,3 +115,113 @@ func (m MessageChain) Record(file string) MessageChain {
 		Data: data,
 	})
 }
+
+// MapText returns a copy of m with fn applied to the text of every text segment, including the content of nodes.
+func (m MessageChain) MapText(fn func(text string) string) MessageChain {
+	mapped := make(MessageChain, 0, len(m))
+	for _, msg := range m {
+		var text Text
+		var node Node
+		switch {
+		case msg.Type == "text" && json.Unmarshal(msg.Data, &text) == nil:
+			mapped = mapped.Text(fn(text.Text))
+		case msg.Type == "node" && json.Unmarshal(msg.Data, &node) == nil && len(node.Content) != 0:
+			mapped = mapped.Node(node.UserId, node.Nickname, node.Content.MapText(fn))
+		default:
+			mapped = append(mapped, msg)
+		}
+	}
+	return mapped
+}
+
+// PlainText returns the text of the text segments of m, including the content of nodes.
+func (m MessageChain) PlainText() string {
+	var b strings.Builder
+	for _, msg := range m {
+		var text Text
+		var node Node
+		switch {
+		case msg.Type == "text" && json.Unmarshal(msg.Data, &text) == nil:
+			b.WriteString(text.Text)
+		case msg.Type == "node" && json.Unmarshal(msg.Data, &node) == nil:
+			b.WriteString(node.Content.PlainText())
+		}
+	}
+	return b.String()
+}
+
+// Node adds a message of a merged-forward message sent by userId with nickname.
+func (m MessageChain) Node(userId string, nickname string, content MessageChain) MessageChain {
+	data, err := json.Marshal(Node{
+		UserId:   userId,
+		Nickname: nickname,
+		Content:  content,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return m.Append(Message{
+		Type: "node",
+		Data: data,
+	})
+}
+
+// TextLen returns the number of characters of the text segments of m.
+func (m MessageChain) TextLen() int {
+	return utf8.RuneCountInString(m.PlainText())
+}
+
+// Split splits m into chains with at most maxLen characters of text, at segment and line boundaries when possible.
+// Other segments stay with the text around them.
+func (m MessageChain) Split(maxLen int) []MessageChain {
+	if maxLen <= 0 || m.TextLen() <= maxLen {
+		return []MessageChain{m}
+	}
+	var (
+		parts   []MessageChain
+		cur     MessageChain
+		pending strings.Builder
+		curLen  int
+	)
+	flushText := func() {
+		if pending.Len() != 0 {
+			cur = cur.Text(pending.String())
+			pending.Reset()
+		}
+	}
+	flush := func() {
+		flushText()
+		if len(cur) != 0 {
+			parts = append(parts, cur)
+		}
+		cur, curLen = nil, 0
+	}
+	for _, msg := range m {
+		var text Text
+		if msg.Type != "text" || json.Unmarshal(msg.Data, &text) != nil {
+			flushText()
+			cur = append(cur, msg)
+			continue
+		}
+		for _, line := range strings.SplitAfter(text.Text, "\n") {
+			for len(line) != 0 {
+				n := utf8.RuneCountInString(line)
+				if curLen+n <= maxLen {
+					pending.WriteString(line)
+					curLen += n
+					break
+				}
+				if curLen == 0 {
+					// a line longer than maxLen
+					head := string([]rune(line)[:maxLen])
+					pending.WriteString(head)
+					line = line[len(head):]
+					curLen = maxLen
+				}
+				flush()
+			}
+		}
+	}
+	flush()
+	return parts
+}
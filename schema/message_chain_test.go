@@ -0,0 +1,34 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	var m MessageChain
+	m = m.Reply(1).Text("aaaa\nbbbb\n").Image("x.png").Text("cccccccccc")
+	parts := m.Split(6)
+	var texts []string
+	for _, part := range parts {
+		assert.LessOrEqual(t, part.TextLen(), 6)
+		texts = append(texts, part.PlainText())
+	}
+	assert.Equal(t, []string{"aaaa\n", "bbbb\n", "cccccc", "cccc"}, texts)
+	assert.Equal(t, "reply", parts[0][0].Type)
+	assert.Equal(t, "image", parts[1][1].Type)
+
+	assert.Equal(t, []MessageChain{m}, m.Split(100))
+	assert.Len(t, MessageChain{}.Text("你好世界你好").Split(4), 2)
+}
+
+func TestNodeText(t *testing.T) {
+	var msg MessageChain
+	msg = msg.Node("1", "bot", MessageChain{}.Text("a secret")).Node("1", "bot", MessageChain{}.Text(" b"))
+	assert.Equal(t, "a secret b", msg.PlainText())
+	masked := msg.MapText(func(text string) string { return strings.ReplaceAll(text, "secret", "***") })
+	assert.Equal(t, "a *** b", masked.PlainText())
+	assert.Equal(t, "node", masked[0].Type)
+}
@@ -0,0 +1,220 @@
+package nsxbot
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrStateNotFound = errors.New("session state not found")
+
+// SessionState is the serializable part of a session, it is saved on every change
+// so a conversation can be resumed after restart.
+type SessionState struct {
+	Key       string                     `json:"key"`
+	Step      int                        `json:"step"`
+	Data      map[string]json.RawMessage `json:"data,omitempty"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+}
+
+func (s *SessionState) clone() *SessionState {
+	state := *s
+	state.Data = maps.Clone(s.Data)
+	return &state
+}
+
+type StateStore interface {
+	// Load returns ErrStateNotFound if there is no state for key.
+	Load(ctx context.Context, key string) (*SessionState, error)
+	Save(ctx context.Context, state *SessionState) error
+	Delete(ctx context.Context, key string) error
+}
+
+type MemoryStateStore struct {
+	mu     sync.RWMutex
+	states map[string]*SessionState
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		states: make(map[string]*SessionState),
+	}
+}
+
+func (m *MemoryStateStore) Load(ctx context.Context, key string) (*SessionState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state, ok := m.states[key]
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	return state.clone(), nil
+}
+
+func (m *MemoryStateStore) Save(ctx context.Context, state *SessionState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[state.Key] = state.clone()
+	return nil
+}
+
+func (m *MemoryStateStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, key)
+	return nil
+}
+
+// FileStateStore saves every session as a json file in dir.
+type FileStateStore struct {
+	dir string
+}
+
+func NewFileStateStore(dir string) (*FileStateStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStateStore{dir: dir}, nil
+}
+
+func (f *FileStateStore) path(key string) string {
+	return filepath.Join(f.dir, base64.RawURLEncoding.EncodeToString([]byte(key))+".json")
+}
+
+func (f *FileStateStore) Load(ctx context.Context, key string) (*SessionState, error) {
+	content, err := os.ReadFile(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state SessionState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (f *FileStateStore) Save(ctx context.Context, state *SessionState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// write to a temp file first, a crash must not leave a half written state
+	tmp, err := os.CreateTemp(f.dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(state.Key))
+}
+
+func (f *FileStateStore) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+var sessionBucket = []byte("sessions")
+
+// BoltStateStore saves sessions in an embedded bbolt database file.
+type BoltStateStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStateStore(path string) (*BoltStateStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStateStore{db: db}, nil
+}
+
+func (b *BoltStateStore) Load(ctx context.Context, key string) (*SessionState, error) {
+	var state *SessionState
+	err := b.db.View(func(tx *bolt.Tx) error {
+		content := tx.Bucket(sessionBucket).Get([]byte(key))
+		if content == nil {
+			return ErrStateNotFound
+		}
+		state = &SessionState{}
+		return json.Unmarshal(content, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (b *BoltStateStore) Save(ctx context.Context, state *SessionState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).Put([]byte(state.Key), content)
+	})
+}
+
+func (b *BoltStateStore) Delete(ctx context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).Delete([]byte(key))
+	})
+}
+
+func (b *BoltStateStore) Close() error {
+	return b.db.Close()
+}
+
+// StorageStateStore saves sessions in a storage bucket, for example the one of Engine.Storage.
+type StorageStateStore struct {
+	bucket storage.Typed[SessionState]
+}
+
+func NewStorageStateStore(bucket storage.Bucket) *StorageStateStore {
+	return &StorageStateStore{bucket: storage.Of[SessionState](bucket)}
+}
+
+func (s *StorageStateStore) Load(ctx context.Context, key string) (*SessionState, error) {
+	state, err := s.bucket.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *StorageStateStore) Save(ctx context.Context, state *SessionState) error {
+	return s.bucket.Set(ctx, state.Key, *state, 0)
+}
+
+func (s *StorageStateStore) Delete(ctx context.Context, key string) error {
+	return s.bucket.Delete(ctx, key)
+}
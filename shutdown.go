@@ -0,0 +1,89 @@
+package nsxbot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/nsxdevx/nsxbot/driver"
+)
+
+var ErrShutdownTimeout = errors.New("shutdown timeout, handlers still running")
+
+// errShutdown is the cause of the handler contexts canceled by the shutdown.
+var errShutdown = errors.New("engine shutdown")
+
+// SetShutdownTimeout sets how long Run waits for running handlers after ctx is done, handlers are canceled then.
+func (e *Engine) SetShutdownTimeout(timeout time.Duration) {
+	e.shutdownTimeout = timeout
+}
+
+// OnShutdown adds a hook called after the handlers are drained and before the drivers are closed.
+func (e *Engine) OnShutdown(hook func(ctx context.Context)) {
+	e.shutdownHooks = append(e.shutdownHooks, hook)
+}
+
+// track adds work started outside of a running handler, such as a job run, to the handlers the shutdown waits for.
+// It returns false once the shutdown started, the work must not start then.
+// Work started by a running handler can add itself to inflight directly, the handler keeps the count above zero.
+func (e *Engine) track() bool {
+	e.inflightMu.Lock()
+	defer e.inflightMu.Unlock()
+	if e.stopping {
+		return false
+	}
+	e.inflight.Add(1)
+	return true
+}
+
+func (e *Engine) shutdown(cancelHandlers context.CancelCauseFunc) error {
+	e.log.Info("Shutting down, waiting for handlers", "timeout", e.shutdownTimeout)
+	e.inflightMu.Lock()
+	e.stopping = true
+	e.inflightMu.Unlock()
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(e.shutdownTimeout)
+	select {
+	case <-done:
+		timer.Stop()
+	case <-timer.C:
+		e.log.Warn("Shutdown timeout, canceling running handlers")
+		errs = append(errs, ErrShutdownTimeout)
+	}
+	cancelHandlers(errShutdown)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.shutdownTimeout)
+	defer cancel()
+	for _, hook := range e.shutdownHooks {
+		hook(ctx)
+	}
+
+	// the mux wrapped by SetThrottle is closed, not the wrapper
+	emitterMux := e.emitterMux
+	for {
+		wrapper, ok := emitterMux.(interface{ Unwrap() driver.EmitterMux })
+		if !ok {
+			break
+		}
+		emitterMux = wrapper.Unwrap()
+	}
+	closers := []any{e.listener}
+	if any(emitterMux) != any(e.listener) {
+		closers = append(closers, emitterMux)
+	}
+	for _, c := range closers {
+		if closer, ok := c.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	e.log.Info("Shutdown complete")
+	return errors.Join(errs...)
+}
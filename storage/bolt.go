@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt is an on-disk storage backed by a bbolt database file.
+// Expired entries are purged when the file is opened and when they are read.
+type Bolt struct {
+	db *bolt.DB
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	b := &Bolt{db: db}
+	if err := b.Purge(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Purge deletes the expired entries of all buckets.
+func (b *Bolt) Purge() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			var expired [][]byte
+			err := bucket.ForEach(func(k, v []byte) error {
+				if _, ok := decode(v); !ok {
+					expired = append(expired, slices.Clone(k))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// a bucket must not be changed while iterating it
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (b *Bolt) Bucket(name string) Bucket {
+	return &boltBucket{db: b.db, name: []byte(name)}
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+type boltBucket struct {
+	db   *bolt.DB
+	name []byte
+}
+
+func (b *boltBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	var expired bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+		if bucket == nil {
+			return ErrNotFound
+		}
+		raw := bucket.Get([]byte(key))
+		data, ok := decode(raw)
+		if !ok {
+			expired = raw != nil
+			return ErrNotFound
+		}
+		// data is only valid during the transaction
+		value = slices.Clone(data)
+		return nil
+	})
+	if expired {
+		b.purge(key)
+	}
+	return value, err
+}
+
+// purge deletes key if it is still expired.
+func (b *boltBucket) purge(key string) {
+	_ = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+		if bucket == nil {
+			return nil
+		}
+		if raw := bucket.Get([]byte(key)); raw != nil {
+			if _, ok := decode(raw); !ok {
+				return bucket.Delete([]byte(key))
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBucket) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(b.name)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), encode(value, ttl))
+	})
+}
+
+func (b *boltBucket) Delete(ctx context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+func (b *boltBucket) Update(ctx context.Context, key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(b.name)
+		if err != nil {
+			return err
+		}
+		old, ok := decode(bucket.Get([]byte(key)))
+		if !ok {
+			old = nil
+		}
+		value, err := fn(slices.Clone(old))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), encode(value, ttl))
+	})
+}
+
+func (b *boltBucket) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if _, ok := decode(v); ok {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (m *Memory) Bucket(name string) Bucket {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bucket, ok := m.buckets[name]
+	if !ok {
+		bucket = &memoryBucket{entries: make(map[string]memoryEntry)}
+		m.buckets[name] = bucket
+	}
+	return bucket
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+type memoryEntry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+func (e memoryEntry) expired() bool {
+	return !e.expireAt.IsZero() && time.Now().After(e.expireAt)
+}
+
+type memoryBucket struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func (b *memoryBucket) get(key string) ([]byte, bool) {
+	entry, ok := b.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if entry.expired() {
+		delete(b.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (b *memoryBucket) set(key string, value []byte, ttl time.Duration) {
+	entry := memoryEntry{value: slices.Clone(value)}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+	b.entries[key] = entry
+}
+
+func (b *memoryBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	value, ok := b.get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(value), nil
+}
+
+func (b *memoryBucket) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.set(key, value, ttl)
+	return nil
+}
+
+func (b *memoryBucket) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, key)
+	return nil
+}
+
+func (b *memoryBucket) Update(ctx context.Context, key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old, _ := b.get(key)
+	value, err := fn(slices.Clone(old))
+	if err != nil {
+		return err
+	}
+	b.set(key, value, ttl)
+	return nil
+}
+
+func (b *memoryBucket) Keys(ctx context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.entries))
+	for key, entry := range b.entries {
+		if entry.expired() {
+			delete(b.entries, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+type Storage interface {
+	// Bucket returns the bucket called name, keys of different buckets do not collide.
+	// Buckets are created on first write.
+	Bucket(name string) Bucket
+	Close() error
+}
+
+type Bucket interface {
+	// Get returns ErrNotFound if the key is absent or expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value, a ttl <= 0 never expires.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Update atomically replaces the value of key with the result of fn, old is nil if the key is absent.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error
+	Keys(ctx context.Context) ([]string, error)
+}
+
+// Typed wraps a Bucket with json encoded values of type V.
+type Typed[V any] struct {
+	Bucket
+}
+
+func Of[V any](bucket Bucket) Typed[V] {
+	return Typed[V]{Bucket: bucket}
+}
+
+func (t Typed[V]) Get(ctx context.Context, key string) (V, error) {
+	var value V
+	data, err := t.Bucket.Get(ctx, key)
+	if err != nil {
+		return value, err
+	}
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+func (t Typed[V]) Set(ctx context.Context, key string, value V, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return t.Bucket.Set(ctx, key, data, ttl)
+}
+
+// Update atomically replaces the value of key, ok is false if the key is absent.
+func (t Typed[V]) Update(ctx context.Context, key string, ttl time.Duration, fn func(old V, ok bool) (V, error)) (V, error) {
+	var value V
+	err := t.Bucket.Update(ctx, key, ttl, func(old []byte) ([]byte, error) {
+		var prev V
+		if old != nil {
+			if err := json.Unmarshal(old, &prev); err != nil {
+				return nil, err
+			}
+		}
+		next, err := fn(prev, old != nil)
+		if err != nil {
+			return nil, err
+		}
+		value = next
+		return json.Marshal(next)
+	})
+	return value, err
+}
+
+// entry encoding of the bolt backend: 8 bytes unix nano expire time, 0 means never, then the value.
+func encode(value []byte, ttl time.Duration) []byte {
+	data := make([]byte, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(data[8:], value)
+	return data
+}
+
+func decode(data []byte) ([]byte, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	expire := int64(binary.BigEndian.Uint64(data))
+	if expire != 0 && time.Now().UnixNano() > expire {
+		return nil, false
+	}
+	return data[8:], true
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func backends(t *testing.T) map[string]Storage {
+	bolt, err := NewBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	return map[string]Storage{
+		"memory": NewMemory(),
+		"bolt":   bolt,
+	}
+}
+
+func TestTyped(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			counter := Of[int](s.Bucket("counter"))
+			_, err := counter.Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			assert.NoError(t, counter.Set(ctx, "a", 1, 0))
+			value, err := counter.Get(ctx, "a")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, value)
+
+			// buckets are namespaced
+			_, err = Of[int](s.Bucket("other")).Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			var wg sync.WaitGroup
+			for range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					counter.Update(ctx, "b", 0, func(old int, ok bool) (int, error) {
+						return old + 1, nil
+					})
+				}()
+			}
+			wg.Wait()
+			value, err = counter.Get(ctx, "b")
+			assert.NoError(t, err)
+			assert.Equal(t, 50, value)
+
+			keys, err := counter.Keys(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, keys)
+
+			assert.NoError(t, counter.Delete(ctx, "a"))
+			_, err = counter.Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			bucket := s.Bucket("ttl")
+			assert.NoError(t, bucket.Set(ctx, "k", []byte("v"), 20*time.Millisecond))
+			value, err := bucket.Get(ctx, "k")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("v"), value)
+
+			time.Sleep(30 * time.Millisecond)
+			_, err = bucket.Get(ctx, "k")
+			assert.ErrorIs(t, err, ErrNotFound)
+			keys, err := bucket.Keys(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, keys)
+		})
+	}
+}
+
+func TestBoltPurge(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "purge.db")
+	b, err := NewBolt(path)
+	assert.NoError(t, err)
+	bucket := b.Bucket("ttl")
+	assert.NoError(t, bucket.Set(ctx, "short", []byte("v"), 10*time.Millisecond))
+	assert.NoError(t, bucket.Set(ctx, "long", []byte("v"), 0))
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, b.Close())
+
+	// expired entries are gone from the file after it is opened again
+	b, err = NewBolt(path)
+	assert.NoError(t, err)
+	defer b.Close()
+	var stored []string
+	assert.NoError(t, b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("ttl")).ForEach(func(k, v []byte) error {
+			stored = append(stored, string(k))
+			return nil
+		})
+	}))
+	assert.Equal(t, []string{"long"}, stored)
+}
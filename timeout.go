@@ -0,0 +1,39 @@
+package nsxbot
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SetHandlerTimeout sets the deadline of every handler, 0 means no deadline, see Timeout for a single route.
+// The deadline cancels the Emitter calls and Sation.Await of the handler.
+func (e *Engine) SetHandlerTimeout(timeout time.Duration) {
+	e.handlerTimeout = timeout
+}
+
+// Timeout is a middleware setting the deadline of the handlers after it.
+func Timeout[T any](timeout time.Duration) HandlerFunc[T] {
+	return func(ctx *Context[T]) {
+		parent, parentCancel := ctx.Context, ctx.cancel
+		timeoutCtx, cancel := context.WithTimeout(parent, timeout)
+		ctx.Context = timeoutCtx
+		ctx.cancel = func() {
+			cancel()
+			if parentCancel != nil {
+				parentCancel()
+			}
+		}
+		ctx.Next()
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+			handlerTimeouts.Inc(ctx.route)
+			ctx.Log.Warn("Handler timeout", "timeout", timeout)
+		}
+		if ctx.cancel == nil {
+			// a detached handler owns the context now
+			return
+		}
+		ctx.Context, ctx.cancel = parent, parentCancel
+		cancel()
+	}
+}